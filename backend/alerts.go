@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Alert types
+const (
+	AlertContentChanged = "content_changed"
+	AlertStatusChanged  = "status_changed"
+	AlertBrokenLinks    = "broken_links"
+)
+
+// AlertSubscription asks to be alerted about a condition on one of the
+// user's jobs, or on all of them when CrawlJobID is 0
+type AlertSubscription struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	UserID     uint   `gorm:"not null;index" json:"user_id"`
+	CrawlJobID uint   `gorm:"index" json:"crawl_job_id"`
+	Type       string `gorm:"type:varchar(30);not null" json:"type"` // content_changed, status_changed, broken_links
+	Threshold  int    `json:"threshold,omitempty"`                   // broken_links only
+	Active     bool   `gorm:"default:true" json:"active"`
+	gorm.Model
+}
+
+// Alert records a subscription firing for a run
+type Alert struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	UserID         uint       `gorm:"not null;index" json:"user_id"`
+	CrawlJobID     uint       `gorm:"not null;index" json:"crawl_job_id"`
+	CrawlRunID     uint       `gorm:"index" json:"crawl_run_id"`
+	SubscriptionID uint       `json:"subscription_id"`
+	Type           string     `gorm:"type:varchar(30)" json:"type"`
+	URL            string     `gorm:"type:text" json:"url"`
+	Message        string     `gorm:"type:text" json:"message"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	gorm.Model
+}
+
+// evaluateAlerts fires the user's subscriptions matching a finished run,
+// comparing it with the job's previous finished run
+func (cs *CrawlerService) evaluateAlerts(run *CrawlRun) {
+	var subs []AlertSubscription
+	cs.db.Where("user_id = ? AND active = ? AND (crawl_job_id = ? OR crawl_job_id = 0)", run.UserID, true, run.CrawlJobID).
+		Find(&subs)
+	if len(subs) == 0 {
+		return
+	}
+
+	var prev CrawlRun
+	hasPrev := cs.db.Where("crawl_job_id = ? AND run_number < ? AND status IN ?", run.CrawlJobID, run.RunNumber, []string{"completed", "error"}).
+		Order("run_number desc").
+		First(&prev).Error == nil
+
+	for _, sub := range subs {
+		var message string
+		switch sub.Type {
+		case AlertContentChanged:
+			if run.ContentChanged {
+				message = "Page content changed"
+				if len(run.ChangedRegions) > 0 {
+					message = "Content changed in " + strings.Join(run.ChangedRegions, ", ")
+				}
+			}
+		case AlertStatusChanged:
+			if hasPrev && prev.StatusCode != run.StatusCode {
+				message = fmt.Sprintf("Status code changed from %s to %s", describeStatus(prev.StatusCode), describeStatus(run.StatusCode))
+			}
+		case AlertBrokenLinks:
+			// Alert once when the count rises to the threshold, not on every run above it
+			if run.BrokenLinks >= sub.Threshold && (!hasPrev || prev.BrokenLinks < sub.Threshold) {
+				message = fmt.Sprintf("%d broken links found (threshold %d)", run.BrokenLinks, sub.Threshold)
+			}
+		}
+		if message == "" {
+			continue
+		}
+
+		alert := Alert{
+			UserID:         run.UserID,
+			CrawlJobID:     run.CrawlJobID,
+			CrawlRunID:     run.ID,
+			SubscriptionID: sub.ID,
+			Type:           sub.Type,
+			URL:            run.URL,
+			Message:        message,
+		}
+		if err := cs.db.Create(&alert).Error; err != nil {
+			log.Printf("Failed to store alert for job %d: %v", run.CrawlJobID, err)
+			continue
+		}
+		notifyAlert(&alert)
+	}
+}
+
+// describeStatus formats a stored status code, where 0 means no response
+func describeStatus(code int) string {
+	if code == 0 {
+		return "no response"
+	}
+	return strconv.Itoa(code)
+}
+
+// Alert handlers
+func createAlertSubscription(c *gin.Context) {
+	var req struct {
+		CrawlJobID uint   `json:"crawl_job_id"`
+		Type       string `json:"type" binding:"required"`
+		Threshold  int    `json:"threshold"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+
+	switch req.Type {
+	case AlertContentChanged, AlertStatusChanged:
+		req.Threshold = 0
+	case AlertBrokenLinks:
+		if req.Threshold < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be at least 1"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be 'content_changed', 'status_changed' or 'broken_links'"})
+		return
+	}
+
+	if req.CrawlJobID != 0 {
+		var job CrawlJob
+		if err := db.Where("id = ? AND user_id = ?", req.CrawlJobID, userID).First(&job).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+	}
+
+	sub := AlertSubscription{
+		UserID:     userID,
+		CrawlJobID: req.CrawlJobID,
+		Type:       req.Type,
+		Threshold:  req.Threshold,
+		Active:     true,
+	}
+	if err := db.Create(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, sub)
+}
+
+func getAlertSubscriptions(c *gin.Context) {
+	query := db.Where("user_id = ?", c.GetUint("user_id"))
+	if jobID := c.Query("crawl_job_id"); jobID != "" {
+		query = query.Where("crawl_job_id = ?", jobID)
+	}
+
+	var subs []AlertSubscription
+	query.Order("id asc").Find(&subs)
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subs})
+}
+
+func deleteAlertSubscription(c *gin.Context) {
+	result := db.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).Delete(&AlertSubscription{})
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Subscription deleted"})
+}
+
+func getAlerts(c *gin.Context) {
+	page := 1
+	limit := 20
+	if p, err := strconv.Atoi(c.Query("page")); err == nil && p > 0 {
+		page = p
+	}
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+
+	query := db.Model(&Alert{}).Where("user_id = ?", c.GetUint("user_id"))
+	if jobID := c.Query("crawl_job_id"); jobID != "" {
+		query = query.Where("crawl_job_id = ?", jobID)
+	}
+	if c.Query("unacknowledged") == "true" {
+		query = query.Where("acknowledged_at IS NULL")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var alerts []Alert
+	query.Order("id desc").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&alerts)
+
+	c.JSON(http.StatusOK, gin.H{
+		"alerts": alerts,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
+
+func acknowledgeAlert(c *gin.Context) {
+	var alert Alert
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).First(&alert).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+
+	if alert.AcknowledgedAt == nil {
+		now := time.Now()
+		db.Model(&alert).Update("acknowledged_at", &now)
+		alert.AcknowledgedAt = &now
+	}
+	c.JSON(http.StatusOK, alert)
+}
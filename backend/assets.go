@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Asset types a page resource is reported under when assets are checked
+const (
+	AssetImage      = "image"
+	AssetScript     = "script"
+	AssetStylesheet = "stylesheet"
+	AssetIcon       = "icon"
+	AssetFont       = "font"
+	AssetVideo      = "video"
+	AssetAudio      = "audio"
+	AssetIframe     = "iframe"
+	AssetOther      = "other"
+)
+
+// Asset types of <link rel="preload"> elements, by their "as" attribute
+var preloadAssetTypes = map[string]string{
+	"image":  AssetImage,
+	"script": AssetScript,
+	"style":  AssetStylesheet,
+	"font":   AssetFont,
+	"video":  AssetVideo,
+	"audio":  AssetAudio,
+}
+
+// extractAssets collects the URLs of the images, scripts, stylesheets, icons,
+// media and frames a page loads. Each URL of a srcset is collected separately.
+// Only http and https URLs are returned.
+func (cs *CrawlerService) extractAssets(doc *html.Node, baseURL *url.URL, state *crawlState) []LinkInfo {
+	var assets []LinkInfo
+	add := func(n *html.Node, attribute, value, assetType string) {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return
+		}
+		asset := cs.processLink(value, baseURL, state)
+		if !strings.HasPrefix(asset.URL, "http://") && !strings.HasPrefix(asset.URL, "https://") {
+			return
+		}
+		asset.AssetType = assetType
+		asset.Element = n.Data
+		asset.Attribute = attribute
+		asset.Rel = htmlAttr(n, "rel")
+		switch n.Data {
+		case "img":
+			asset.AnchorText = truncateText(htmlAttr(n, "alt"), maxAnchorTextLength)
+		case "iframe":
+			asset.AnchorText = truncateText(htmlAttr(n, "title"), maxAnchorTextLength)
+		}
+		assets = append(assets, asset)
+	}
+	addSrcset := func(n *html.Node, assetType string) {
+		for _, candidate := range parseSrcset(htmlAttr(n, "srcset")) {
+			add(n, "srcset", candidate, assetType)
+		}
+	}
+
+	cs.traverseNode(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		switch n.Data {
+		case "img":
+			add(n, "src", htmlAttr(n, "src"), AssetImage)
+			addSrcset(n, AssetImage)
+		case "script":
+			add(n, "src", htmlAttr(n, "src"), AssetScript)
+		case "link":
+			if assetType := linkAssetType(n); assetType != "" {
+				add(n, "href", htmlAttr(n, "href"), assetType)
+			}
+		case "source":
+			// Sources belong to the <picture>, <video> or <audio> around them
+			assetType := AssetImage
+			if n.Parent != nil && (n.Parent.Data == "video" || n.Parent.Data == "audio") {
+				assetType = n.Parent.Data
+			}
+			add(n, "src", htmlAttr(n, "src"), assetType)
+			addSrcset(n, assetType)
+		case "video":
+			add(n, "src", htmlAttr(n, "src"), AssetVideo)
+			add(n, "poster", htmlAttr(n, "poster"), AssetImage)
+		case "audio":
+			add(n, "src", htmlAttr(n, "src"), AssetAudio)
+		case "iframe":
+			add(n, "src", htmlAttr(n, "src"), AssetIframe)
+		}
+	})
+
+	return assets
+}
+
+// linkAssetType returns the asset type of a <link> element, or "" when it
+// does not load a stylesheet, icon or preloaded resource
+func linkAssetType(n *html.Node) string {
+	for _, rel := range strings.Fields(strings.ToLower(htmlAttr(n, "rel"))) {
+		switch rel {
+		case "stylesheet":
+			return AssetStylesheet
+		case "icon", "apple-touch-icon", "apple-touch-icon-precomposed", "mask-icon":
+			return AssetIcon
+		case "modulepreload":
+			return AssetScript
+		case "preload":
+			if assetType, ok := preloadAssetTypes[strings.ToLower(htmlAttr(n, "as"))]; ok {
+				return assetType
+			}
+			return AssetOther
+		}
+	}
+	return ""
+}
+
+// parseSrcset returns the URLs of the image candidates in a srcset
+// attribute, such as "a.png 1x, b.png 2x". A URL ends at whitespace, so
+// commas within it are kept unless they end it.
+func parseSrcset(srcset string) []string {
+	var urls []string
+	s := srcset
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f,")
+		if s == "" {
+			return urls
+		}
+		end := strings.IndexAny(s, " \t\n\r\f")
+		if end < 0 {
+			end = len(s)
+		}
+		candidate := s[:end]
+		s = s[end:]
+
+		// A trailing comma ends a candidate without descriptors
+		if trimmed := strings.TrimRight(candidate, ","); trimmed != candidate {
+			urls = append(urls, trimmed)
+			continue
+		}
+		urls = append(urls, candidate)
+
+		// Skip the descriptors, which end at the next comma outside parentheses
+		depth := 0
+		i := 0
+		for ; i < len(s); i++ {
+			if s[i] == '(' {
+				depth++
+			} else if s[i] == ')' && depth > 0 {
+				depth--
+			} else if s[i] == ',' && depth == 0 {
+				break
+			}
+		}
+		s = s[i:]
+	}
+}
+
+// groupBrokenAssets separates the broken assets from the broken links and
+// groups them by asset type
+func groupBrokenAssets(broken []BrokenLink) ([]BrokenLink, map[string][]BrokenLink) {
+	var links []BrokenLink
+	assets := make(map[string][]BrokenLink)
+	for _, l := range broken {
+		if l.AssetType == "" {
+			links = append(links, l)
+		} else {
+			assets[l.AssetType] = append(assets[l.AssetType], l)
+		}
+	}
+	return links, assets
+}
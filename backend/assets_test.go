@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseSrcset(t *testing.T) {
+	tests := []struct {
+		srcset string
+		want   []string
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"a.png", []string{"a.png"}},
+		{"a.png 1x, b.png 2x", []string{"a.png", "b.png"}},
+		{"a.png 480w,b.png 800w", []string{"a.png", "b.png"}},
+		// As in browsers, a comma followed by a URL without whitespace is part of the URL
+		{"a.png,b.png 2x", []string{"a.png,b.png"}},
+		{"a.png, b.png", []string{"a.png", "b.png"}},
+		{"\n  a.png 1x,\n  b.png 2x\n", []string{"a.png", "b.png"}},
+		// Commas inside a URL do not end it
+		{"/img/w_100,h_50/a.png 1x, /img/w_200,h_100/a.png 2x", []string{"/img/w_100,h_50/a.png", "/img/w_200,h_100/a.png"}},
+		{"data:image/png;base64,AAAA 1x, b.png 2x", []string{"data:image/png;base64,AAAA", "b.png"}},
+		// Commas inside parenthesised descriptors do not end the candidate
+		{"a.png (max-width: 1px, 2px) 1x, b.png 2x", []string{"a.png", "b.png"}},
+		{",,a.png 1x,,", []string{"a.png"}},
+	}
+	for _, tt := range tests {
+		if got := parseSrcset(tt.srcset); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSrcset(%q) = %q, want %q", tt.srcset, got, tt.want)
+		}
+	}
+}
+
+func TestLinkAssetType(t *testing.T) {
+	tests := []struct {
+		rel, as string
+		want    string
+	}{
+		{"stylesheet", "", AssetStylesheet},
+		{"alternate Stylesheet", "", AssetStylesheet},
+		{"shortcut icon", "", AssetIcon},
+		{"apple-touch-icon", "", AssetIcon},
+		{"modulepreload", "", AssetScript},
+		{"preload", "script", AssetScript},
+		{"preload", "unknown", AssetOther},
+		{"canonical", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		n := &html.Node{Type: html.ElementNode, Data: "link", Attr: []html.Attribute{{Key: "rel", Val: tt.rel}, {Key: "as", Val: tt.as}}}
+		if got := linkAssetType(n); got != tt.want {
+			t.Errorf("linkAssetType(rel=%q, as=%q) = %q, want %q", tt.rel, tt.as, got, tt.want)
+		}
+	}
+}
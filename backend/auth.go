@@ -0,0 +1,559 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Ways a job authenticates its crawl
+const (
+	AuthBasic   = "basic"
+	AuthBearer  = "bearer"
+	AuthCookies = "cookies"
+	AuthForm    = "form"
+)
+
+// Most cookies or login form fields a job may give
+const maxAuthEntries = 50
+
+// Key credentials are encrypted with, derived from CREDENTIALS_KEY. Jobs with
+// credentials cannot be created without it.
+var credentialsKey []byte
+
+// setCredentialsKey derives the credentials key from a secret of any length
+func setCredentialsKey(secret string) {
+	if secret == "" {
+		credentialsKey = nil
+		return
+	}
+	sum := sha256.Sum256([]byte(secret))
+	credentialsKey = sum[:]
+}
+
+// CrawlAuth holds the credentials a job crawls with. It is stored encrypted
+// and never returned by the API. Authorization headers are only sent to hosts
+// in the job's site scope, and the session is shared by every page fetch and
+// link check of a run.
+type CrawlAuth struct {
+	Type     string       `json:"type"`               // basic, bearer, cookies, form
+	Username string       `json:"username,omitempty"` // basic
+	Password string       `json:"password,omitempty"` // basic
+	Token    string       `json:"token,omitempty"`    // bearer
+	Cookies  []AuthCookie `json:"cookies,omitempty"`  // cookies
+	Form     *FormLogin   `json:"form,omitempty"`     // form
+}
+
+// AuthCookie is a cookie imported into a job's session. Without a domain it
+// is set for the job URL's host.
+type AuthCookie struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Domain string `json:"domain,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Secure bool   `json:"secure,omitempty"`
+}
+
+// FormLogin is a login step performed before a crawl. The login page is
+// fetched, its login form filled in with the hidden inputs it already has and
+// the given fields, and submitted. Without a success check, the login
+// succeeds when the page it ends on has no login form.
+type FormLogin struct {
+	URL         string            `json:"url"`
+	Fields      map[string]string `json:"fields"`
+	SuccessText string            `json:"success_text,omitempty"` // must appear on the page after logging in
+	SuccessURL  string            `json:"success_url,omitempty"`  // must be part of the URL logging in ends on
+	FailureText string            `json:"failure_text,omitempty"` // fails the login when it appears
+}
+
+// Validate checks credentials given for a job
+func (a *CrawlAuth) Validate() error {
+	switch a.Type {
+	case AuthBasic:
+		if a.Username == "" || strings.Contains(a.Username, ":") {
+			return fmt.Errorf("basic auth needs a username without ':'")
+		}
+	case AuthBearer:
+		if a.Token == "" || strings.ContainsAny(a.Token, "\r\n") {
+			return fmt.Errorf("bearer auth needs a single line token")
+		}
+	case AuthCookies:
+		if len(a.Cookies) == 0 || len(a.Cookies) > maxAuthEntries {
+			return fmt.Errorf("cookie auth needs between 1 and %d cookies", maxAuthEntries)
+		}
+		for _, c := range a.Cookies {
+			if !isToken(c.Name) || strings.ContainsAny(c.Value, ";\r\n\" ") || strings.ContainsAny(c.Domain, "/:@ ") {
+				return fmt.Errorf("invalid cookie %q", c.Name)
+			}
+		}
+	case AuthForm:
+		if a.Form == nil {
+			return fmt.Errorf("form auth needs a form")
+		}
+		u, err := url.ParseRequestURI(a.Form.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("form url must be an http or https URL")
+		}
+		if len(a.Form.Fields) == 0 || len(a.Form.Fields) > maxAuthEntries {
+			return fmt.Errorf("form auth needs between 1 and %d fields", maxAuthEntries)
+		}
+	default:
+		return fmt.Errorf("auth type must be '%s', '%s', '%s' or '%s'", AuthBasic, AuthBearer, AuthCookies, AuthForm)
+	}
+	return nil
+}
+
+// header returns the Authorization header the credentials send, if any
+func (a *CrawlAuth) header() string {
+	switch a.Type {
+	case AuthBasic:
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(a.Username+":"+a.Password))
+	case AuthBearer:
+		return "Bearer " + a.Token
+	}
+	return ""
+}
+
+// encryptCredentials seals credentials with AES-GCM for storage
+func encryptCredentials(auth *CrawlAuth) (string, error) {
+	plaintext, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return sealSecret(plaintext)
+}
+
+// decryptCredentials opens credentials sealed by encryptCredentials
+func decryptCredentials(data string) (*CrawlAuth, error) {
+	plaintext, err := openSecret(data)
+	if err != nil {
+		return nil, err
+	}
+	var auth CrawlAuth
+	if err := json.Unmarshal(plaintext, &auth); err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+// sealSecret encrypts data with AES-GCM under the credentials key
+func sealSecret(plaintext []byte) (string, error) {
+	if credentialsKey == nil {
+		return "", errors.New("credentials key is not configured")
+	}
+	gcm, err := credentialsCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret decrypts data sealed by sealSecret
+func openSecret(data string) ([]byte, error) {
+	if credentialsKey == nil {
+		return nil, errors.New("credentials key is not configured")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := credentialsCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("credentials are truncated")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		// Most likely CREDENTIALS_KEY changed since the job was created
+		return nil, errors.New("credentials cannot be decrypted with the configured key")
+	}
+	return plaintext, nil
+}
+
+func credentialsCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(credentialsKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Shown in place of every secret value the API returns
+const redactedValue = "********"
+
+// Name secret values that cannot be decrypted are kept under, still sealed, so
+// they are stored back unchanged. It is no valid header or cookie name.
+const sealedSecretName = ""
+
+// secretValues holds named secrets, such as the custom headers and cookies of
+// crawl settings. They are stored sealed like credentials, and their values
+// are redacted whenever they are encoded as JSON: in API responses, the
+// settings stored on runs and webhook payloads.
+type secretValues map[string]string
+
+// MarshalJSON returns the names with their values redacted
+func (s secretValues) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	redacted := make(map[string]string, len(s))
+	for name := range s {
+		if name != sealedSecretName {
+			redacted[name] = redactedValue
+		}
+	}
+	return json.Marshal(redacted)
+}
+
+// Value seals the values for storage
+func (s secretValues) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	if sealed, ok := s[sealedSecretName]; ok {
+		return sealed, nil
+	}
+	plaintext, err := json.Marshal(map[string]string(s))
+	if err != nil {
+		return nil, err
+	}
+	return sealSecret(plaintext)
+}
+
+// Scan opens stored values. Values that cannot be decrypted are kept sealed
+// rather than failing every query that loads them; see sealed.
+func (s *secretValues) Scan(src interface{}) error {
+	var data string
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		data = string(v)
+	case string:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into secret values", src)
+	}
+
+	switch {
+	case data == "" || data == "null":
+		*s = nil
+		return nil
+	case strings.HasPrefix(data, "{"):
+		// Stored before secret values were sealed
+		return json.Unmarshal([]byte(data), (*map[string]string)(s))
+	}
+	plaintext, err := openSecret(data)
+	if err != nil {
+		*s = secretValues{sealedSecretName: data}
+		return nil
+	}
+	return json.Unmarshal(plaintext, (*map[string]string)(s))
+}
+
+// sealed reports whether the values could not be decrypted when they were
+// loaded, most likely because CREDENTIALS_KEY changed
+func (s secretValues) sealed() bool {
+	_, ok := s[sealedSecretName]
+	return ok
+}
+
+// restoreRedacted replaces values given as redactedValue with the stored value
+// of the same name, so settings read from the API can be sent back unchanged
+func (s secretValues) restoreRedacted(stored secretValues) {
+	for name, value := range s {
+		if old, ok := stored[name]; ok && value == redactedValue {
+			s[name] = old
+		}
+	}
+}
+
+// importCookies sets a job's imported cookies in its session
+func importCookies(jar http.CookieJar, cookies []AuthCookie, jobURL string) {
+	job, err := url.Parse(jobURL)
+	if err != nil {
+		return
+	}
+	for _, c := range cookies {
+		host := strings.TrimPrefix(c.Domain, ".")
+		if host == "" {
+			host = job.Hostname()
+		}
+		path := c.Path
+		if path == "" {
+			path = "/"
+		}
+		u := &url.URL{Scheme: "https", Host: host, Path: path}
+		jar.SetCookies(u, []*http.Cookie{{
+			Name:   c.Name,
+			Value:  c.Value,
+			Domain: c.Domain,
+			Path:   path,
+			Secure: c.Secure,
+		}})
+	}
+}
+
+// loginWithForm performs a job's form login step in its session, so the
+// cookies the site sets are sent with the rest of the crawl
+func (cs *CrawlerService) loginWithForm(state *crawlState, form *FormLogin) error {
+	resp, _, err := cs.fetchPolitely(state, state.pageClient, http.MethodGet, form.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch login page: %v", err)
+	}
+	pageURL := resp.Request.URL
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read login page: %v", err)
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("login page responded with status %d", resp.StatusCode)
+	}
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("failed to parse login page: %v", err)
+	}
+
+	formNode := findLoginForm(doc)
+	if formNode == nil {
+		return fmt.Errorf("no form found on %s", pageURL)
+	}
+	action, err := pageURL.Parse(htmlAttr(formNode, "action"))
+	if err != nil {
+		return fmt.Errorf("invalid form action: %v", err)
+	}
+	values := formValues(formNode)
+	for name, value := range form.Fields {
+		values.Set(name, value)
+	}
+
+	method := strings.ToUpper(htmlAttr(formNode, "method"))
+	if method != http.MethodPost {
+		method = http.MethodGet
+	}
+	resp, err = cs.submitForm(state, method, action, values)
+	if err != nil {
+		return fmt.Errorf("failed to submit login form: %v", err)
+	}
+	finalURL := resp.Request.URL.String()
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read login response: %v", err)
+	}
+
+	// Check the outcome
+	page := string(body)
+	switch {
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("login responded with status %d", resp.StatusCode)
+	case form.FailureText != "" && strings.Contains(page, form.FailureText):
+		return fmt.Errorf("login page shows %q", form.FailureText)
+	case form.SuccessText != "" && !strings.Contains(page, form.SuccessText):
+		return fmt.Errorf("%q not found after logging in", form.SuccessText)
+	case form.SuccessURL != "" && !strings.Contains(finalURL, form.SuccessURL):
+		return fmt.Errorf("login ended on %s", finalURL)
+	case form.SuccessText == "" && form.SuccessURL == "":
+		if doc, err := html.Parse(strings.NewReader(page)); err == nil && cs.hasLoginForm(doc) {
+			return fmt.Errorf("login form still shown on %s", finalURL)
+		}
+	}
+	return nil
+}
+
+// submitForm sends form values within the host's limits and follows any
+// redirects as GET requests, the way browsers do after a login
+func (cs *CrawlerService) submitForm(state *crawlState, method string, action *url.URL, values url.Values) (*http.Response, error) {
+	var body io.Reader
+	target := *action
+	if method == http.MethodPost {
+		body = strings.NewReader(values.Encode())
+	} else {
+		target.RawQuery = values.Encode()
+	}
+	req, err := http.NewRequestWithContext(state.ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = cs.requestHeader(state, nil)
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	limits := cs.hosts.Limits(state.settings, 0)
+	release, err := cs.hosts.Acquire(state.ctx, target.Host, limits)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := state.pageClient.Do(req)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	location := resp.Header.Get("Location")
+	if !isRedirectStatus(resp.StatusCode) || location == "" {
+		return resp, nil
+	}
+	next, err := resp.Request.URL.Parse(location)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err = cs.fetchPolitely(state, state.pageClient, http.MethodGet, next.String(), nil)
+	return resp, err
+}
+
+// findLoginForm returns the first form with a password field, or the first
+// form if none has one
+func findLoginForm(doc *html.Node) *html.Node {
+	var first, login *html.Node
+	var visit func(n *html.Node, form *html.Node)
+	visit = func(n *html.Node, form *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "form":
+				form = n
+				if first == nil {
+					first = n
+				}
+			case "input":
+				if form != nil && login == nil && strings.EqualFold(htmlAttr(n, "type"), "password") {
+					login = form
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c, form)
+		}
+	}
+	visit(doc, nil)
+	if login != nil {
+		return login
+	}
+	return first
+}
+
+// formValues returns the values a form submits as it stands: its named
+// inputs, checked boxes, selects and text areas, without buttons
+func formValues(form *html.Node) url.Values {
+	values := url.Values{}
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			name := htmlAttr(n, "name")
+			switch n.Data {
+			case "input":
+				switch strings.ToLower(htmlAttr(n, "type")) {
+				case "submit", "button", "image", "reset", "file":
+				case "checkbox", "radio":
+					if _, checked := lookupAttr(n, "checked"); checked && name != "" {
+						value, ok := lookupAttr(n, "value")
+						if !ok {
+							value = "on"
+						}
+						values.Add(name, value)
+					}
+				default:
+					if name != "" {
+						values.Add(name, htmlAttr(n, "value"))
+					}
+				}
+			case "textarea":
+				if name != "" {
+					values.Add(name, nodeText(n))
+				}
+			case "select":
+				if name != "" {
+					values.Add(name, selectedOption(n))
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(form)
+	return values
+}
+
+// selectedOption returns the value of a select's selected option, or of its
+// first option
+func selectedOption(sel *html.Node) string {
+	var first, selected *html.Node
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "option" {
+			if first == nil {
+				first = n
+			}
+			if _, ok := lookupAttr(n, "selected"); ok && selected == nil {
+				selected = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(sel)
+	if selected == nil {
+		selected = first
+	}
+	if selected == nil {
+		return ""
+	}
+	if value, ok := lookupAttr(selected, "value"); ok {
+		return value
+	}
+	return strings.TrimSpace(nodeText(selected))
+}
+
+// authenticate opens a job's authenticated session: it decrypts the job's
+// credentials, applies them to the session's clients and performs the login
+// step of form auth
+func (cs *CrawlerService) authenticate(state *crawlState) error {
+	auth, err := decryptCredentials(state.job.Credentials)
+	if err != nil {
+		return err
+	}
+	state.privateSession = true
+
+	switch auth.Type {
+	case AuthBasic, AuthBearer:
+		for _, client := range []*http.Client{state.pageClient, state.linkClient} {
+			client.Transport = &scopedHeaderTransport{base: client.Transport, header: http.Header{"Authorization": {auth.header()}}, scope: state.scope}
+		}
+	case AuthCookies:
+		importCookies(state.pageClient.Jar, auth.Cookies, state.job.URL)
+	case AuthForm:
+		if err := cs.loginWithForm(state, auth.Form); err != nil {
+			return err
+		}
+		log.Printf("Logged in at %s (Job ID: %d)", auth.Form.URL, state.job.ID)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useCredentialsKey sets the credentials key for a test and restores it after
+func useCredentialsKey(t *testing.T, secret string) {
+	t.Helper()
+	saved := credentialsKey
+	t.Cleanup(func() { credentialsKey = saved })
+	setCredentialsKey(secret)
+}
+
+func TestCredentialsRoundTrip(t *testing.T) {
+	useCredentialsKey(t, "test secret")
+
+	tests := []*CrawlAuth{
+		{Type: AuthBasic, Username: "alice", Password: "p@ss:word"},
+		{Type: AuthBearer, Token: "abc.def.ghi"},
+		{Type: AuthCookies, Cookies: []AuthCookie{{Name: "session", Value: "xyz", Domain: "example.com", Secure: true}}},
+		{Type: AuthForm, Form: &FormLogin{URL: "https://example.com/login", Fields: map[string]string{"user": "alice", "pass": "secret"}, SuccessText: "Log out"}},
+	}
+	for _, auth := range tests {
+		sealed, err := encryptCredentials(auth)
+		if err != nil {
+			t.Fatalf("encryptCredentials(%s): %v", auth.Type, err)
+		}
+		if strings.Contains(sealed, "alice") || strings.Contains(sealed, "abc.def") {
+			t.Errorf("%s credentials are stored in plaintext: %s", auth.Type, sealed)
+		}
+		opened, err := decryptCredentials(sealed)
+		if err != nil {
+			t.Fatalf("decryptCredentials(%s): %v", auth.Type, err)
+		}
+		if !reflect.DeepEqual(opened, auth) {
+			t.Errorf("round trip gave %+v, want %+v", opened, auth)
+		}
+	}
+}
+
+func TestEncryptCredentialsUsesFreshNonces(t *testing.T) {
+	useCredentialsKey(t, "test secret")
+
+	auth := &CrawlAuth{Type: AuthBearer, Token: "token"}
+	first, err := encryptCredentials(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := encryptCredentials(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("encrypting the same credentials twice gave the same ciphertext")
+	}
+}
+
+func TestDecryptCredentialsErrors(t *testing.T) {
+	useCredentialsKey(t, "test secret")
+	sealed, err := encryptCredentials(&CrawlAuth{Type: AuthBearer, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(sealed)
+	tampered := append([]byte(nil), raw...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not base64", "not base64!"},
+		{"truncated", base64.StdEncoding.EncodeToString(raw[:4])},
+		{"tampered", base64.StdEncoding.EncodeToString(tampered)},
+	}
+	for _, tt := range tests {
+		if _, err := decryptCredentials(tt.data); err == nil {
+			t.Errorf("%s: decryptCredentials succeeded, want an error", tt.name)
+		}
+	}
+
+	setCredentialsKey("another secret")
+	if _, err := decryptCredentials(sealed); err == nil {
+		t.Error("decrypting with a different key succeeded")
+	}
+
+	setCredentialsKey("")
+	if _, err := decryptCredentials(sealed); err == nil {
+		t.Error("decrypting without a key succeeded")
+	}
+	if _, err := encryptCredentials(&CrawlAuth{Type: AuthBearer, Token: "token"}); err == nil {
+		t.Error("encrypting without a key succeeded")
+	}
+}
+
+func TestCrawlAuthValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  CrawlAuth
+		valid bool
+	}{
+		{"basic", CrawlAuth{Type: AuthBasic, Username: "alice", Password: "x"}, true},
+		{"basic without username", CrawlAuth{Type: AuthBasic, Password: "x"}, false},
+		{"basic username with colon", CrawlAuth{Type: AuthBasic, Username: "a:b"}, false},
+		{"bearer", CrawlAuth{Type: AuthBearer, Token: "abc"}, true},
+		{"bearer with newline", CrawlAuth{Type: AuthBearer, Token: "abc\r\nX-Evil: 1"}, false},
+		{"cookies", CrawlAuth{Type: AuthCookies, Cookies: []AuthCookie{{Name: "sid", Value: "1"}}}, true},
+		{"no cookies", CrawlAuth{Type: AuthCookies}, false},
+		{"cookie value with semicolon", CrawlAuth{Type: AuthCookies, Cookies: []AuthCookie{{Name: "sid", Value: "1; path=/"}}}, false},
+		{"cookie domain with path", CrawlAuth{Type: AuthCookies, Cookies: []AuthCookie{{Name: "sid", Value: "1", Domain: "example.com/x"}}}, false},
+		{"form", CrawlAuth{Type: AuthForm, Form: &FormLogin{URL: "https://example.com/login", Fields: map[string]string{"u": "a"}}}, true},
+		{"form without fields", CrawlAuth{Type: AuthForm, Form: &FormLogin{URL: "https://example.com/login"}}, false},
+		{"form with ftp url", CrawlAuth{Type: AuthForm, Form: &FormLogin{URL: "ftp://example.com/", Fields: map[string]string{"u": "a"}}}, false},
+		{"unknown type", CrawlAuth{Type: "digest"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.auth.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: Validate() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestCrawlAuthHeader(t *testing.T) {
+	tests := []struct {
+		auth CrawlAuth
+		want string
+	}{
+		{CrawlAuth{Type: AuthBasic, Username: "alice", Password: "secret"}, "Basic YWxpY2U6c2VjcmV0"},
+		{CrawlAuth{Type: AuthBearer, Token: "abc"}, "Bearer abc"},
+		{CrawlAuth{Type: AuthCookies}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.auth.header(); got != tt.want {
+			t.Errorf("header(%s) = %q, want %q", tt.auth.Type, got, tt.want)
+		}
+	}
+}
+
+func TestSecretValuesStorage(t *testing.T) {
+	useCredentialsKey(t, "test secret")
+
+	values := secretValues{"X-Api-Key": "abc123", "Authorization": "Bearer token"}
+	stored, err := values.Value()
+	if err != nil {
+		t.Fatalf("Value: %v", err)
+	}
+	if s, ok := stored.(string); !ok || strings.Contains(s, "abc123") || strings.Contains(s, "X-Api-Key") {
+		t.Fatalf("Value stored %v, want sealed data", stored)
+	}
+
+	var opened secretValues
+	if err := opened.Scan([]byte(stored.(string))); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !reflect.DeepEqual(opened, values) || opened.sealed() {
+		t.Errorf("Scan gave %v, want %v", opened, values)
+	}
+
+	// Values stored before they were sealed are still read
+	var legacy secretValues
+	if err := legacy.Scan(`{"consent":"yes"}`); err != nil || legacy["consent"] != "yes" {
+		t.Errorf("Scan of plaintext JSON gave %v, %v", legacy, err)
+	}
+	for _, empty := range []interface{}{nil, "", "null"} {
+		var none secretValues
+		if err := none.Scan(empty); err != nil || none != nil {
+			t.Errorf("Scan(%v) gave %v, %v, want nil", empty, none, err)
+		}
+	}
+	if v, err := (secretValues{}).Value(); v != nil || err != nil {
+		t.Errorf("empty values stored %v, %v, want NULL", v, err)
+	}
+
+	// Values that cannot be decrypted stay sealed and are stored back as they were
+	setCredentialsKey("another secret")
+	var undecryptable secretValues
+	if err := undecryptable.Scan(stored); err != nil {
+		t.Fatalf("Scan with another key: %v", err)
+	}
+	if !undecryptable.sealed() {
+		t.Fatalf("Scan with another key gave %v, want sealed values", undecryptable)
+	}
+	if again, err := undecryptable.Value(); err != nil || again != stored {
+		t.Errorf("sealed values stored as %v, %v, want them unchanged", again, err)
+	}
+
+	setCredentialsKey("")
+	if _, err := values.Value(); err == nil {
+		t.Error("storing values without a key succeeded")
+	}
+}
+
+func TestSecretValuesRedacted(t *testing.T) {
+	settings := CrawlSettings{
+		UserAgent: "Monitor/2.0",
+		Headers:   secretValues{"X-Api-Key": "abc123"},
+		Cookies:   secretValues{"session": "s3cret"},
+	}
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encoded), "abc123") || strings.Contains(string(encoded), "s3cret") {
+		t.Errorf("encoded settings contain secret values: %s", encoded)
+	}
+	if !strings.Contains(string(encoded), `"X-Api-Key":"`+redactedValue+`"`) {
+		t.Errorf("encoded settings do not list the redacted header: %s", encoded)
+	}
+
+	sealed, _ := json.Marshal(secretValues{sealedSecretName: "data"})
+	if string(sealed) != "{}" {
+		t.Errorf("sealed values encoded as %s, want {}", sealed)
+	}
+}
+
+func TestSecretValuesRestoreRedacted(t *testing.T) {
+	stored := secretValues{"X-Api-Key": "abc123", "X-Old": "old"}
+	given := secretValues{"X-Api-Key": redactedValue, "X-New": redactedValue, "X-Old": "changed"}
+	given.restoreRedacted(stored)
+
+	want := secretValues{"X-Api-Key": "abc123", "X-New": redactedValue, "X-Old": "changed"}
+	if !reflect.DeepEqual(given, want) {
+		t.Errorf("restoreRedacted gave %v, want %v", given, want)
+	}
+}
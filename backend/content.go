@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// Monitored regions per job
+	maxMonitorSelectors = 20
+	// Anchor text stored with a link is cut off after this many characters
+	maxAnchorTextLength = 200
+)
+
+// Elements whose text is not page content
+var nonContentElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+}
+
+// detectChanges compares a run's content hashes with the previous completed
+// run of the job and adds the outcome to the run's result updates. When the
+// job monitors regions, only changes within them count as content changes.
+func (cs *CrawlerService) detectChanges(run *CrawlRun, result *CrawlResult, updates map[string]interface{}) {
+	regions, _ := json.Marshal(result.RegionHashes)
+	updates["region_hashes"] = string(regions)
+	updates["content_changed"] = false
+	updates["changed_regions"] = "[]"
+	if run == nil {
+		return
+	}
+
+	var prev CrawlRun
+	err := cs.db.Where("crawl_job_id = ? AND run_number < ? AND status = ?", run.CrawlJobID, run.RunNumber, "completed").
+		Order("run_number desc").
+		First(&prev).Error
+	if err != nil || prev.ContentHash == "" {
+		return
+	}
+
+	changed := []string{}
+	for selector, hash := range result.RegionHashes {
+		if before, exists := prev.RegionHashes[selector]; exists && before != hash {
+			changed = append(changed, selector)
+		}
+	}
+	sort.Strings(changed)
+	encoded, _ := json.Marshal(changed)
+	updates["changed_regions"] = string(encoded)
+
+	if len(result.RegionHashes) > 0 {
+		updates["content_changed"] = len(changed) > 0
+	} else {
+		updates["content_changed"] = prev.ContentHash != result.ContentHash
+	}
+}
+
+// anchorText returns the text a link is labelled with, falling back to its
+// aria-label or title, or the alt text of an image inside it
+func anchorText(n *html.Node) string {
+	text := nodeText(n)
+	if text == "" {
+		text = strings.TrimSpace(htmlAttr(n, "aria-label"))
+	}
+	if text == "" {
+		text = strings.TrimSpace(htmlAttr(n, "title"))
+	}
+	if text == "" {
+		var findAlt func(*html.Node)
+		findAlt = func(n *html.Node) {
+			for c := n.FirstChild; c != nil && text == ""; c = c.NextSibling {
+				if c.Type == html.ElementNode && c.Data == "img" {
+					text = strings.TrimSpace(htmlAttr(c, "alt"))
+				}
+				findAlt(c)
+			}
+		}
+		findAlt(n)
+	}
+	return truncateText(text, maxAnchorTextLength)
+}
+
+// truncateText shortens text to at most limit runes, marking the cut with "…"
+func truncateText(text string, limit int) string {
+	text = strings.TrimSpace(text)
+	if runes := []rune(text); len(runes) > limit {
+		text = string(runes[:limit]) + "…"
+	}
+	return text
+}
+
+// contentHash returns a hash of a page's visible text with whitespace
+// collapsed, so markup and formatting changes alone do not change it
+func contentHash(doc *html.Node) string {
+	return hashText(nodeText(doc))
+}
+
+// regionHashes hashes the text of the elements matched by each selector. A
+// selector matching nothing gets an empty hash.
+func regionHashes(doc *html.Node, selectors []string) map[string]string {
+	if len(selectors) == 0 {
+		return nil
+	}
+	hashes := make(map[string]string, len(selectors))
+	for _, raw := range selectors {
+		sel, err := parseSelector(raw)
+		if err != nil {
+			continue
+		}
+		matches := sel.Match(doc)
+		if len(matches) == 0 {
+			hashes[raw] = ""
+			continue
+		}
+		texts := make([]string, 0, len(matches))
+		for _, n := range matches {
+			texts = append(texts, nodeText(n))
+		}
+		hashes[raw] = hashText(strings.Join(texts, "\n"))
+	}
+	return hashes
+}
+
+func hashText(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// nodeText returns the normalized text content of a node
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && nonContentElements[n.Data] {
+			return
+		}
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// cssSelector is a parsed selector list. It supports a subset of CSS: type,
+// #id, .class, [attr] and [attr=value] selectors, combined with descendant
+// and child (>) combinators, and comma-separated alternatives.
+type cssSelector [][]selectorPart
+
+type selectorPart struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+	// The element must be a direct child of the element matching the previous part
+	child bool
+}
+
+type attrSelector struct {
+	name     string
+	value    string
+	hasValue bool
+}
+
+// parseSelector parses a selector list
+func parseSelector(raw string) (cssSelector, error) {
+	var sel cssSelector
+	for _, alt := range strings.Split(raw, ",") {
+		parts, err := parseSelectorSequence(strings.TrimSpace(alt))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", raw, err)
+		}
+		sel = append(sel, parts)
+	}
+	return sel, nil
+}
+
+// parseSelectorSequence parses compound selectors joined by combinators
+func parseSelectorSequence(s string) ([]selectorPart, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty selector")
+	}
+
+	var parts []selectorPart
+	child := false
+	i := 0
+	for i < len(s) {
+		switch {
+		case s[i] == ' ' || s[i] == '\t' || s[i] == '\n':
+			i++
+		case s[i] == '>':
+			if len(parts) == 0 || child {
+				return nil, fmt.Errorf("unexpected '>'")
+			}
+			child = true
+			i++
+		default:
+			part, n, err := parseCompoundSelector(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			part.child = child
+			child = false
+			parts = append(parts, part)
+			i += n
+		}
+	}
+	if child || len(parts) == 0 {
+		return nil, fmt.Errorf("selector ends with a combinator")
+	}
+	return parts, nil
+}
+
+// parseCompoundSelector parses one compound selector, such as div.card[data-id],
+// returning it and the number of bytes consumed
+func parseCompoundSelector(s string) (selectorPart, int, error) {
+	var part selectorPart
+	i := 0
+
+	if tag := readSelectorName(s); tag != "" {
+		part.tag = strings.ToLower(tag)
+		i += len(tag)
+	} else if strings.HasPrefix(s, "*") {
+		i++
+	}
+
+	for i < len(s) {
+		switch s[i] {
+		case '#', '.':
+			name := readSelectorName(s[i+1:])
+			if name == "" {
+				return part, 0, fmt.Errorf("missing name after %q", s[i])
+			}
+			if s[i] == '#' {
+				part.id = name
+			} else {
+				part.classes = append(part.classes, name)
+			}
+			i += 1 + len(name)
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return part, 0, fmt.Errorf("unclosed '['")
+			}
+			attr := attrSelector{}
+			body := s[i+1 : i+end]
+			if eq := strings.IndexByte(body, '='); eq >= 0 {
+				attr.name = strings.TrimSpace(body[:eq])
+				attr.value = strings.Trim(strings.TrimSpace(body[eq+1:]), `"'`)
+				attr.hasValue = true
+			} else {
+				attr.name = strings.TrimSpace(body)
+			}
+			if attr.name == "" {
+				return part, 0, fmt.Errorf("missing attribute name")
+			}
+			attr.name = strings.ToLower(attr.name)
+			part.attrs = append(part.attrs, attr)
+			i += end + 1
+		case ' ', '\t', '\n', '>':
+			return part, i, nil
+		default:
+			return part, 0, fmt.Errorf("unsupported character %q", s[i])
+		}
+	}
+	if i == 0 {
+		return part, 0, fmt.Errorf("empty compound selector")
+	}
+	return part, i, nil
+}
+
+func readSelectorName(s string) string {
+	end := 0
+	for end < len(s) {
+		c := s[end]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			end++
+			continue
+		}
+		break
+	}
+	return s[:end]
+}
+
+// Match returns every element under root matched by the selector, in document order
+func (sel cssSelector) Match(root *html.Node) []*html.Node {
+	var matches []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, parts := range sel {
+				if matchSelectorParts(n, parts) {
+					matches = append(matches, n)
+					break
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	return matches
+}
+
+// matchSelectorParts matches the last part against n and the earlier parts
+// against its ancestors
+func matchSelectorParts(n *html.Node, parts []selectorPart) bool {
+	last := parts[len(parts)-1]
+	if !last.matches(n) {
+		return false
+	}
+	if len(parts) == 1 {
+		return true
+	}
+	rest := parts[:len(parts)-1]
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if matchSelectorParts(p, rest) {
+			return true
+		}
+		if last.child {
+			break
+		}
+	}
+	return false
+}
+
+func (part selectorPart) matches(n *html.Node) bool {
+	if part.tag != "" && n.Data != part.tag {
+		return false
+	}
+	if part.id != "" && htmlAttr(n, "id") != part.id {
+		return false
+	}
+	if len(part.classes) > 0 {
+		classes := strings.Fields(htmlAttr(n, "class"))
+		for _, want := range part.classes {
+			found := false
+			for _, have := range classes {
+				if have == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	for _, attr := range part.attrs {
+		value, exists := lookupAttr(n, attr.name)
+		if !exists || (attr.hasValue && value != attr.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func htmlAttr(n *html.Node, name string) string {
+	value, _ := lookupAttr(n, name)
+	return value
+}
+
+func lookupAttr(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, name) {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseSelectorErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"div,",
+		"> div",
+		"div >",
+		"div > > p",
+		"div.",
+		"#",
+		"[data-id",
+		"[=x]",
+		"div:first-child",
+		"a + b",
+	}
+	for _, raw := range tests {
+		if _, err := parseSelector(raw); err == nil {
+			t.Errorf("parseSelector(%q) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	sel, err := parseSelector(`DIV#main.card.wide[data-id="7"][hidden] > a, span`)
+	if err != nil {
+		t.Fatalf("parseSelector: %v", err)
+	}
+	if len(sel) != 2 {
+		t.Fatalf("got %d alternatives, want 2", len(sel))
+	}
+	if len(sel[0]) != 2 {
+		t.Fatalf("got %d parts in the first alternative, want 2", len(sel[0]))
+	}
+
+	first := sel[0][0]
+	if first.tag != "div" || first.id != "main" || first.child {
+		t.Errorf("first part = %+v", first)
+	}
+	if strings.Join(first.classes, " ") != "card wide" {
+		t.Errorf("classes = %v, want [card wide]", first.classes)
+	}
+	wantAttrs := []attrSelector{{name: "data-id", value: "7", hasValue: true}, {name: "hidden"}}
+	if len(first.attrs) != len(wantAttrs) {
+		t.Fatalf("attrs = %+v, want %+v", first.attrs, wantAttrs)
+	}
+	for i, attr := range wantAttrs {
+		if first.attrs[i] != attr {
+			t.Errorf("attrs[%d] = %+v, want %+v", i, first.attrs[i], attr)
+		}
+	}
+
+	if second := sel[0][1]; second.tag != "a" || !second.child {
+		t.Errorf("second part = %+v, want a child a", second)
+	}
+	if sel[1][0].tag != "span" {
+		t.Errorf("second alternative = %+v, want span", sel[1][0])
+	}
+}
+
+func TestSelectorMatch(t *testing.T) {
+	const page = `<html><body>
+<div id="main" class="card wide">
+  <a id="a1" href="/1">one</a>
+  <p><a id="a2" href="/2">two</a></p>
+</div>
+<div class="card"><span id="s1" data-id="7">x</span><span id="s2" data-id="8">y</span></div>
+<section><a id="a3" href="/3">three</a></section>
+</body></html>`
+	root, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{"a", []string{"a1", "a2", "a3"}},
+		{"#main a", []string{"a1", "a2"}},
+		{"#main > a", []string{"a1"}},
+		{"div.card > p > a", []string{"a2"}},
+		{".card.wide a", []string{"a1", "a2"}},
+		{".wide.missing a", nil},
+		{"span[data-id]", []string{"s1", "s2"}},
+		{"[data-id='8']", []string{"s2"}},
+		{"section a, #s1", []string{"s1", "a3"}},
+		{"*#a2", []string{"a2"}},
+	}
+	for _, tt := range tests {
+		sel, err := parseSelector(tt.selector)
+		if err != nil {
+			t.Errorf("parseSelector(%q): %v", tt.selector, err)
+			continue
+		}
+		var got []string
+		for _, n := range sel.Match(root) {
+			got = append(got, htmlAttr(n, "id"))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%q matched %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+}
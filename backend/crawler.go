@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,11 +17,29 @@ import (
 	"gorm.io/gorm"
 )
 
+// Crawl modes and site crawl budgets
+const (
+	CrawlModePage = "page"
+	CrawlModeSite = "site"
+
+	defaultSiteMaxDepth = 3
+	defaultSiteMaxPages = 100
+	maxSiteDepth        = 10
+	maxSitePages        = 1000
+)
+
 // CrawlerService handles web crawling operations
 type CrawlerService struct {
-	db     *gorm.DB
-	client *http.Client
-	mutex  sync.RWMutex
+	db            *gorm.DB
+	client        *http.Client
+	transport     *http.Transport // shared by the clients of every crawl, see openSession
+	mutex         sync.RWMutex
+	userAgent     string
+	robots        *RobotsCache
+	respectRobots bool
+	hosts         *HostLimiter
+	links         *LinkChecker
+	linkCacheTTL  time.Duration
 }
 
 // CrawlResult represents the result of a crawl operation
@@ -35,6 +56,8 @@ type CrawlResult struct {
 	InternalLinks    int
 	ExternalLinks    int
 	BrokenLinks      []BrokenLinkInfo
+	BrokenAssets     []BrokenLinkInfo
+	Links            []LinkInfo
 	HasLoginForm     bool
 	MetaTitle        string
 	MetaDescription  string
@@ -46,6 +69,13 @@ type CrawlResult struct {
 	JSONLDSnippet    string
 	MicrodataSnippet string
 	RDFaSnippet      string
+	StatusCode       int
+	ContentType      string
+	ResourceType     string
+	ContentLength    int64
+	Headers          map[string]string
+	ContentHash      string
+	RegionHashes     map[string]string
 }
 
 // BrokenLinkInfo contains information about broken links
@@ -53,6 +83,24 @@ type BrokenLinkInfo struct {
 	URL        string
 	StatusCode int
 	Error      string
+	ErrorCode  string // see the LinkError constants
+	Cached     bool   // status came from the shared link cache
+	AssetType  string // see the Asset constants; empty for anchors
+
+	// Where the link appeared on the page
+	AnchorText  string
+	Element     string
+	Attribute   string
+	Rel         string
+	Occurrences int
+}
+
+// SkippedLinkInfo describes a link that was not fetched and why
+type SkippedLinkInfo struct {
+	URL     string
+	PageURL string
+	Reason  string
+	Detail  string
 }
 
 // LinkInfo contains information about a link
@@ -62,125 +110,273 @@ type LinkInfo struct {
 	IsValid    bool
 	StatusCode int
 	Error      string
+	AnchorText string
+	Element    string
+	Attribute  string
+	Rel        string
+	AssetType  string // see the Asset constants; empty for anchors
+	Action     string // see the Rule constants
+	Rule       string // pattern of the URL rule that decided the action
+}
+
+// crawlState holds per-job state shared by every page fetched during a crawl
+type crawlState struct {
+	ctx      context.Context
+	job      *CrawlJob
+	run      *CrawlRun
+	settings CrawlSettings
+
+	// Clients for page fetches and link checks, see openSession
+	pageClient *http.Client
+	linkClient *http.Client
+
+	// Check every link instead of using the shared link status cache
+	freshLinks bool
+	// The session carries the job's credentials, its own cookies, headers
+	// or User-Agent, or its own redirect settings, so link checks neither
+	// use nor fill the shared link status cache
+	privateSession bool
+
+	// Hosts that count as the job's site, and the rules deciding which
+	// links are followed and checked
+	scope *scopeMatcher
+	rules urlRules
+
+	// Link check results, so a link repeated across pages is checked once
+	linkMutex  sync.Mutex
+	linkStatus map[string]BrokenLinkInfo
+	skipped    map[string]SkippedLinkInfo
+
+	// Redirect chains followed during the crawl
+	redirectMutex sync.Mutex
+	redirects     []RedirectChain
+}
+
+func newCrawlState(ctx context.Context, job *CrawlJob, settings CrawlSettings) *crawlState {
+	// Rules were validated when the job and its profile were saved
+	rules, _ := compileURLRules(settings.URLRules)
+	return &crawlState{
+		ctx:        ctx,
+		job:        job,
+		settings:   settings,
+		freshLinks: deref(settings.BypassLinkCache) || job.FreshLinkChecks,
+		scope:      newScopeMatcher(job.URL, settings.Scope),
+		rules:      rules,
+		linkStatus: make(map[string]BrokenLinkInfo),
+		skipped:    make(map[string]SkippedLinkInfo),
+	}
+}
+
+// runID returns the run that rows stored during this crawl belong to
+func (s *crawlState) runID() uint {
+	if s.run == nil {
+		return 0
+	}
+	return s.run.ID
+}
+
+// cancelled reports whether the job has been asked to stop
+func (s *crawlState) cancelled() bool {
+	return s.ctx.Err() != nil
+}
+
+// shuttingDown reports whether the job was cancelled by a server shutdown
+// rather than stopped by the user
+func (s *crawlState) shuttingDown() bool {
+	return errors.Is(context.Cause(s.ctx), errShuttingDown)
+}
+
+// checkLink returns the status of a link, checking it at most once per crawl
+func (s *crawlState) checkLink(linkURL string, check func(string) BrokenLinkInfo) (BrokenLinkInfo, bool) {
+	s.linkMutex.Lock()
+	info, seen := s.linkStatus[linkURL]
+	s.linkMutex.Unlock()
+	if !seen {
+		info = check(linkURL)
+		s.linkMutex.Lock()
+		s.linkStatus[linkURL] = info
+		s.linkMutex.Unlock()
+	}
+	return info, info.ErrorCode != ""
+}
+
+// skipLink records a link that was not fetched, once per crawl
+func (s *crawlState) skipLink(info SkippedLinkInfo) {
+	s.linkMutex.Lock()
+	defer s.linkMutex.Unlock()
+	if _, exists := s.skipped[info.URL]; !exists {
+		s.skipped[info.URL] = info
+	}
+}
+
+// NewCrawlerService creates a new crawler service. Every request it sends,
+// robots.txt and sitemaps included, connects only where destinations allow.
+func NewCrawlerService(db *gorm.DB, destinations *DestinationPolicy) *CrawlerService {
+	transport := &http.Transport{
+		DialContext:         destinations.DialContext(),
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}
+	userAgent := getEnv("CRAWLER_USER_AGENT", "WebCrawlerBot/1.0")
+
+	cs := &CrawlerService{
+		db:            db,
+		client:        client,
+		transport:     transport,
+		userAgent:     userAgent,
+		robots:        NewRobotsCache(client, userAgent),
+		respectRobots: getEnv("RESPECT_ROBOTS_TXT", "true") == "true",
+		hosts: NewHostLimiter(HostLimits{
+			MaxConcurrent: getEnvInt("HOST_MAX_CONCURRENCY", 2),
+			MinInterval:   time.Duration(getEnvInt("HOST_REQUEST_INTERVAL_MS", 200)) * time.Millisecond,
+		}),
+		linkCacheTTL: time.Duration(getEnvInt("LINK_CACHE_TTL_SECONDS", 3600)) * time.Second,
+	}
+	cs.links = NewLinkChecker(cs.fetchPolitely, getEnvInt("LINK_CHECK_RETRIES", 2))
+	return cs
 }
 
-// NewCrawlerService creates a new crawler service
-func NewCrawlerService(db *gorm.DB) *CrawlerService {
-	return &CrawlerService{
-		db: db,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConns:        100,
-				MaxIdleConnsPerHost: 10,
-				IdleConnTimeout:     90 * time.Second,
-			},
-		},
+// robotsAllowed reports whether robots.txt allows fetching the URL, with the
+// matching rule when it does not
+func (cs *CrawlerService) robotsAllowed(ctx context.Context, rawURL string) (bool, string) {
+	if !cs.respectRobots {
+		return true, ""
 	}
+	return cs.robots.Allowed(ctx, rawURL)
 }
 
-// CrawlURL performs the main crawling operation
-func (cs *CrawlerService) CrawlURL(job *CrawlJob, cancelChan <-chan bool) {
-	defer func() {
-		// Clean up cancellation channel
-		delete(jobCancellations, job.ID)
-	}()
+// saveSkippedLinks stores the links a crawl skipped on the job's current run
+func (cs *CrawlerService) saveSkippedLinks(job *CrawlJob, state *crawlState) {
+	for _, info := range state.skipped {
+		cs.db.Create(&SkippedLink{
+			CrawlJobID: job.ID,
+			CrawlRunID: state.runID(),
+			URL:        info.URL,
+			PageURL:    info.PageURL,
+			Reason:     info.Reason,
+			Detail:     info.Detail,
+		})
+	}
+	cs.db.Model(job).Update("skipped_links", len(state.skipped))
+	if state.run != nil {
+		cs.db.Model(state.run).Update("skipped_links", len(state.skipped))
+	}
+}
 
+// CrawlURL performs the main crawling operation. Cancelling ctx aborts any
+// in-flight requests and ends the job as stopped, or requeues it if the
+// server is shutting down.
+func (cs *CrawlerService) CrawlURL(ctx context.Context, job *CrawlJob) {
 	// Update job status to running
 	now := time.Now()
 	cs.db.Model(job).Updates(map[string]interface{}{
-		"status":     "running",
-		"started_at": &now,
+		"status":        "running",
+		"started_at":    &now,
+		"pages_crawled": 0,
 	})
 
 	log.Printf("Starting crawl for URL: %s (Job ID: %d)", job.URL, job.ID)
+	notifyJobStatus(job, EventRunning, "")
+
+	// The job's profile and settings are resolved once for the whole crawl
+	settings := cs.resolveSettings(job)
+	state := newCrawlState(ctx, job, settings)
+	cs.openSession(state)
+
+	// Every execution is recorded as a run, keeping earlier results intact
+	run, err := cs.startRun(job, settings, now)
+	if err != nil {
+		cs.failJob(job, fmt.Errorf("failed to record crawl run: %v", err), state)
+		return
+	}
+	state.run = run
+
+	if err := settings.checkSecrets(); err != nil {
+		cs.failJob(job, err, state)
+		return
+	}
+
+	// Jobs with credentials authenticate their session before crawling
+	if job.AuthType != "" {
+		if err := cs.authenticate(state); err != nil {
+			cs.failJob(job, fmt.Errorf("authentication failed: %v", err), state)
+			return
+		}
+	}
+
+	if job.Mode == CrawlModeSite {
+		cs.crawlSite(job, state)
+		return
+	}
 
 	// Perform the actual crawling
-	result, err := cs.performCrawl(job.URL, cancelChan)
+	result, err := cs.performCrawl(job.URL, state)
 	if err != nil {
-		// Check if it was cancelled
-		completed := time.Now()
-		status := "error"
-		
-		select {
-		case <-cancelChan:
-			status = "stopped"
-			log.Printf("Crawl cancelled for URL: %s (Job ID: %d)", job.URL, job.ID)
-		default:
-			log.Printf("Crawl failed for URL: %s (Job ID: %d) - Error: %v", job.URL, job.ID, err)
-		}
-
-		cs.db.Model(job).Updates(map[string]interface{}{
-			"status":        status,
-			"error_message": err.Error(),
-			"completed_at":  &completed,
-		})
+		cs.failJob(job, err, state)
 		return
 	}
 
 	// Check if cancelled before updating results
-	select {
-	case <-cancelChan:
-		completed := time.Now()
-		cs.db.Model(job).Updates(map[string]interface{}{
-			"status":       "stopped",
-			"completed_at": &completed,
-		})
+	if state.cancelled() {
+		cs.stopJob(job, state)
 		log.Printf("Crawl cancelled before saving results for URL: %s (Job ID: %d)", job.URL, job.ID)
 		return
-	default:
 	}
 
 	// Update job with results - using map to avoid field name issues
 	completed := time.Now()
-	updates := map[string]interface{}{
-		"status":         "completed",
-		"completed_at":   &completed,
-		"html_version":   result.HTMLVersion,
-		"page_title":     result.PageTitle,
-		"h1_count":       result.H1Count,
-		"h2_count":       result.H2Count,
-		"h3_count":       result.H3Count,
-		"h4_count":       result.H4Count,
-		"h5_count":       result.H5Count,
-		"h6_count":       result.H6Count,
-		"internal_links": result.InternalLinks,
-		"external_links": result.ExternalLinks,
-		"broken_links":   len(result.BrokenLinks),
-		"has_login_form": result.HasLoginForm,
-		"meta_title":     result.MetaTitle,
-		"meta_description": result.MetaDescription,
-		"canonical":      result.Canonical,
-		"has_jsonld": result.HasJSONLD,
-		"has_microdata": result.HasMicrodata,
-		"has_rdfa": result.HasRDFa,
-		"jsonld_snippet": result.JSONLDSnippet,
-		"microdata_snippet": result.MicrodataSnippet,
-		"rdfa_snippet": result.RDFaSnippet,
+	updates := resultUpdates(result)
+	updates["status"] = "completed"
+	updates["completed_at"] = &completed
+	updates["broken_links"] = len(result.BrokenLinks)
+	updates["broken_assets"] = len(result.BrokenAssets)
+	updates["pages_crawled"] = 1
+	cs.detectChanges(state.run, result, updates)
+
+	updated := cs.updateRunningJob(job, updates)
+	cs.finishRun(state.run, updates, updated)
+	if !updated {
+		return
 	}
+	cs.saveSkippedLinks(job, state)
+	cs.saveRedirectChains(job, state)
+	notifyJobStatus(job, EventCompleted, "")
 
-	cs.db.Model(job).Updates(updates)
-
-	// Store broken links
-	for _, link := range result.BrokenLinks {
+	// Store broken links and assets
+	for _, link := range append(result.BrokenLinks, result.BrokenAssets...) {
 		brokenLink := BrokenLink{
-			CrawlJobID: job.ID,
-			URL:        link.URL,
-			StatusCode: link.StatusCode,
+			CrawlJobID:   job.ID,
+			CrawlRunID:   state.runID(),
+			URL:          link.URL,
+			StatusCode:   link.StatusCode,
+			ErrorCode:    link.ErrorCode,
+			ErrorMessage: link.Error,
+			Cached:       link.Cached,
+			PageURL:      job.URL,
+			AnchorText:   link.AnchorText,
+			Element:      link.Element,
+			Attribute:    link.Attribute,
+			Rel:          link.Rel,
+			Occurrences:  link.Occurrences,
+			AssetType:    link.AssetType,
 		}
 		cs.db.Create(&brokenLink)
 	}
 
-	// --- Store all discovered internal links for this job ---
-	// Delete old internal links for this job
-	cs.db.Where("from_job_id = ?", job.ID).Delete(&InternalLink{})
-	// Save new internal links
-	links := cs.extractLinksFromURL(job.URL)
-	for _, l := range links {
+	// --- Store all discovered internal links for this run ---
+	for _, l := range result.Links {
 		if l.IsInternal {
 			cs.db.Create(&InternalLink{
-				FromJobID: job.ID,
-				ToURL:     l.URL,
+				FromJobID:  job.ID,
+				CrawlRunID: state.runID(),
+				FromURL:    job.URL,
+				ToURL:      l.URL,
 			})
 		}
 	}
@@ -190,25 +386,352 @@ func (cs *CrawlerService) CrawlURL(job *CrawlJob, cancelChan <-chan bool) {
 	cs.db.Where("user_id = ?", job.UserID).Find(&allJobs)
 	for _, j := range allJobs {
 		var inboundCount int64
-		cs.db.Model(&InternalLink{}).Where("to_url = ? AND from_job_id != ?", j.URL, j.ID).Count(&inboundCount)
+		cs.db.Model(&InternalLink{}).
+			Where("to_url = ? AND from_job_id != ?", j.URL, j.ID).
+			Where("crawl_run_id IN (SELECT current_run_id FROM crawl_jobs WHERE user_id = ?)", job.UserID).
+			Count(&inboundCount)
 		isOrphan := inboundCount == 0
 		cs.db.Model(&CrawlJob{}).Where("id = ?", j.ID).Updates(map[string]interface{}{
 			"inbound_internal_links": inboundCount,
-			"is_orphan": isOrphan,
+			"is_orphan":              isOrphan,
 		})
 	}
 
-	log.Printf("Crawl completed for URL: %s (Job ID: %d) - Title: %s, Internal: %d, External: %d, Broken: %d", 
-		job.URL, job.ID, result.PageTitle, result.InternalLinks, result.ExternalLinks, len(result.BrokenLinks))
+	log.Printf("Crawl completed for URL: %s (Job ID: %d) - Title: %s, Internal: %d, External: %d, Broken: %d, Broken assets: %d",
+		job.URL, job.ID, result.PageTitle, result.InternalLinks, result.ExternalLinks, len(result.BrokenLinks), len(result.BrokenAssets))
+}
+
+// crawlSite walks internal links breadth-first from the job URL, storing a
+// CrawlPage row for every page visited until the depth or page budget runs out
+func (cs *CrawlerService) crawlSite(job *CrawlJob, state *crawlState) {
+	maxDepth := job.MaxDepth
+	maxPages := job.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultSiteMaxPages
+	}
+
+	type frontierItem struct {
+		url    string
+		parent string
+		depth  int
+		source string
+	}
+
+	root := pageKey(job.URL, state.settings.Normalization)
+	queue := []frontierItem{{url: root, source: "entry"}}
+	visited := map[string]bool{root: true}
+
+	// Seed URLs, such as sitemap entries, are crawled alongside the entry page
+	var seeds []CrawlSeed
+	cs.db.Where("crawl_job_id = ?", job.ID).Order("id asc").Find(&seeds)
+	for _, seed := range seeds {
+		key := pageKey(seed.URL, state.settings.Normalization)
+		if visited[key] || !isCrawlable(key) {
+			continue
+		}
+		if action := state.rules.Action(key); action == RuleCheckOnly || action == RuleIgnore {
+			continue
+		}
+		visited[key] = true
+		queue = append(queue, frontierItem{url: key, source: "sitemap"})
+	}
+
+	var rootResult *CrawlResult
+	pagesCrawled := 0
+	brokenTotal := 0
+	brokenAssetsTotal := 0
+
+	for len(queue) > 0 && pagesCrawled < maxPages {
+		if state.cancelled() {
+			break
+		}
+
+		item := queue[0]
+		queue = queue[1:]
+
+		// Seeds outside the site are skipped like off-site links. They are
+		// checked once the entry page has put the host it ends on in scope.
+		if item.source == "sitemap" {
+			if u, err := url.Parse(item.url); err != nil || !state.scope.Contains(u.Hostname()) {
+				log.Printf("Skipping off-site seed: %s (Job ID: %d)", item.url, job.ID)
+				continue
+			}
+		}
+
+		result, err := cs.performCrawl(item.url, state)
+		pagesCrawled++
+
+		page := CrawlPage{
+			CrawlJobID: job.ID,
+			CrawlRunID: state.runID(),
+			URL:        item.url,
+			ParentURL:  item.parent,
+			Depth:      item.depth,
+			Source:     item.source,
+		}
+
+		if err != nil {
+			// The site crawl cannot proceed without its entry page
+			if rootResult == nil && item.url == root {
+				cs.failJob(job, err, state)
+				return
+			}
+			page.Status = "error"
+			page.ErrorMessage = err.Error()
+			page.ErrorCode = fetchErrorCode(err)
+			cs.db.Create(&page)
+			log.Printf("Site crawl page failed: %s (Job ID: %d) - Error: %v", item.url, job.ID, err)
+			continue
+		}
+
+		page.Status = "completed"
+		page.HTMLVersion = result.HTMLVersion
+		page.PageTitle = result.PageTitle
+		page.H1Count = result.H1Count
+		page.H2Count = result.H2Count
+		page.H3Count = result.H3Count
+		page.H4Count = result.H4Count
+		page.H5Count = result.H5Count
+		page.H6Count = result.H6Count
+		page.InternalLinks = result.InternalLinks
+		page.ExternalLinks = result.ExternalLinks
+		page.BrokenLinks = len(result.BrokenLinks)
+		page.BrokenAssets = len(result.BrokenAssets)
+		page.HasLoginForm = result.HasLoginForm
+		page.MetaTitle = result.MetaTitle
+		page.MetaDescription = result.MetaDescription
+		page.Canonical = result.Canonical
+		page.StatusCode = result.StatusCode
+		page.ContentType = result.ContentType
+		page.ResourceType = result.ResourceType
+		page.ContentLength = result.ContentLength
+		page.ContentHash = result.ContentHash
+		cs.db.Create(&page)
+
+		if rootResult == nil {
+			rootResult = result
+		}
+
+		// Store broken links and assets found on this page
+		for _, link := range append(result.BrokenLinks, result.BrokenAssets...) {
+			cs.db.Create(&BrokenLink{
+				CrawlJobID:   job.ID,
+				CrawlRunID:   state.runID(),
+				URL:          link.URL,
+				StatusCode:   link.StatusCode,
+				ErrorCode:    link.ErrorCode,
+				ErrorMessage: link.Error,
+				Cached:       link.Cached,
+				PageURL:      item.url,
+				AnchorText:   link.AnchorText,
+				Element:      link.Element,
+				Attribute:    link.Attribute,
+				Rel:          link.Rel,
+				Occurrences:  link.Occurrences,
+				AssetType:    link.AssetType,
+			})
+		}
+		brokenTotal += len(result.BrokenLinks)
+		brokenAssetsTotal += len(result.BrokenAssets)
+
+		// Record the internal link graph and extend the frontier
+		for _, l := range result.Links {
+			if !l.IsInternal {
+				continue
+			}
+			target := pageKey(l.URL, state.settings.Normalization)
+			cs.db.Create(&InternalLink{
+				FromJobID:  job.ID,
+				CrawlRunID: state.runID(),
+				FromURL:    item.url,
+				ToURL:      target,
+			})
+			if item.depth >= maxDepth || visited[target] || !isCrawlable(target) || l.Action == RuleCheckOnly {
+				continue
+			}
+			// Disallowed pages were already recorded as skipped by the link check
+			if allowed, _ := cs.robotsAllowed(state.ctx, target); !allowed {
+				continue
+			}
+			visited[target] = true
+			queue = append(queue, frontierItem{url: target, parent: item.url, depth: item.depth + 1, source: "link"})
+		}
+
+		cs.db.Model(job).Update("pages_crawled", pagesCrawled)
+		notifyJobProgress(job, "pages", pagesCrawled, maxPages)
+	}
+
+	if state.cancelled() {
+		cs.stopJob(job, state)
+		log.Printf("Site crawl cancelled for URL: %s (Job ID: %d) after %d pages", job.URL, job.ID, pagesCrawled)
+		return
+	}
+
+	cs.updatePageLinkCounts(job, state.runID())
+	cs.saveSkippedLinks(job, state)
+	cs.saveRedirectChains(job, state)
+
+	// The job summarises the entry page, with broken links totalled across the site
+	completed := time.Now()
+	updates := resultUpdates(rootResult)
+	updates["status"] = "completed"
+	updates["completed_at"] = &completed
+	updates["broken_links"] = brokenTotal
+	updates["broken_assets"] = brokenAssetsTotal
+	updates["pages_crawled"] = pagesCrawled
+	cs.detectChanges(state.run, rootResult, updates)
+	updated := cs.updateRunningJob(job, updates)
+	cs.finishRun(state.run, updates, updated)
+	if updated {
+		notifyJobStatus(job, EventCompleted, "")
+	}
+
+	log.Printf("Site crawl completed for URL: %s (Job ID: %d) - Pages: %d, Broken: %d, Broken assets: %d",
+		job.URL, job.ID, pagesCrawled, brokenTotal, brokenAssetsTotal)
+}
+
+// updatePageLinkCounts computes inbound internal links and orphan status for
+// every page of a site crawl run, using only links discovered during that run
+func (cs *CrawlerService) updatePageLinkCounts(job *CrawlJob, runID uint) {
+	var pages []CrawlPage
+	cs.db.Where("crawl_job_id = ? AND crawl_run_id = ?", job.ID, runID).Find(&pages)
+	for _, p := range pages {
+		var inboundCount int64
+		cs.db.Model(&InternalLink{}).
+			Where("from_job_id = ? AND crawl_run_id = ? AND to_url = ? AND from_url != ?", job.ID, runID, p.URL, p.URL).
+			Count(&inboundCount)
+		// The entry page is reachable by definition; sitemap seeds may not be
+		isOrphan := inboundCount == 0 && p.Source != "entry"
+		cs.db.Model(&CrawlPage{}).Where("id = ?", p.ID).Updates(map[string]interface{}{
+			"inbound_internal_links": inboundCount,
+			"is_orphan":              isOrphan,
+		})
+	}
+}
+
+// failJob marks a job as errored, or stopped if it was cancelled
+func (cs *CrawlerService) failJob(job *CrawlJob, err error, state *crawlState) {
+	if state.cancelled() {
+		log.Printf("Crawl cancelled for URL: %s (Job ID: %d)", job.URL, job.ID)
+		cs.stopJob(job, state)
+		return
+	}
+
+	log.Printf("Crawl failed for URL: %s (Job ID: %d) - Error: %v", job.URL, job.ID, err)
+	completed := time.Now()
+	updates := map[string]interface{}{
+		"status":        "error",
+		"error_message": err.Error(),
+		"error_code":    fetchErrorCode(err),
+		"completed_at":  &completed,
+	}
+	updated := cs.updateRunningJob(job, updates)
+	cs.finishRun(state.run, updates, updated)
+	if updated {
+		// Keep the redirects that led to the failure, such as a loop
+		cs.saveRedirectChains(job, state)
+		notifyJobStatus(job, EventError, err.Error())
+	}
+}
+
+// stopJob marks a cancelled job as stopped. A job interrupted by a server
+// shutdown goes back on the queue instead, without using up an attempt.
+func (cs *CrawlerService) stopJob(job *CrawlJob, state *crawlState) {
+	if state.shuttingDown() {
+		now := time.Now()
+		updated := cs.updateRunningJob(job, map[string]interface{}{
+			"status":    "queued",
+			"queued_at": &now,
+			"attempts":  gorm.Expr("attempts - 1"),
+		})
+		// The interrupted run is kept; the retry is recorded as a new run
+		cs.finishRun(state.run, map[string]interface{}{
+			"status":        "stopped",
+			"error_message": errShuttingDown.Error(),
+			"completed_at":  &now,
+		}, true)
+		if updated {
+			log.Printf("Requeued job %d interrupted by shutdown", job.ID)
+			notifyJobStatus(job, EventQueued, "Requeued after server shutdown")
+		}
+		return
+	}
+
+	completed := time.Now()
+	updates := map[string]interface{}{
+		"status":        "stopped",
+		"error_message": errJobStopped.Error(),
+		"completed_at":  &completed,
+	}
+	updated := cs.updateRunningJob(job, updates)
+	cs.finishRun(state.run, updates, updated)
+	if updated {
+		notifyJobStatus(job, EventStopped, "")
+	}
+}
+
+// updateRunningJob updates a job only while it is still running, so a crawl
+// winding down after a stop cannot overwrite a rerun that requeued the job.
+// It reports whether the job was updated.
+func (cs *CrawlerService) updateRunningJob(job *CrawlJob, updates map[string]interface{}) bool {
+	result := cs.db.Model(job).Where("status = ?", "running").Updates(updates)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// resultUpdates maps a crawl result onto crawl_jobs columns
+func resultUpdates(result *CrawlResult) map[string]interface{} {
+	headers, _ := json.Marshal(result.Headers)
+	return map[string]interface{}{
+		"html_version":      result.HTMLVersion,
+		"page_title":        result.PageTitle,
+		"h1_count":          result.H1Count,
+		"h2_count":          result.H2Count,
+		"h3_count":          result.H3Count,
+		"h4_count":          result.H4Count,
+		"h5_count":          result.H5Count,
+		"h6_count":          result.H6Count,
+		"internal_links":    result.InternalLinks,
+		"external_links":    result.ExternalLinks,
+		"has_login_form":    result.HasLoginForm,
+		"meta_title":        result.MetaTitle,
+		"meta_description":  result.MetaDescription,
+		"canonical":         result.Canonical,
+		"has_jsonld":        result.HasJSONLD,
+		"has_microdata":     result.HasMicrodata,
+		"has_rdfa":          result.HasRDFa,
+		"jsonld_snippet":    result.JSONLDSnippet,
+		"microdata_snippet": result.MicrodataSnippet,
+		"rdfa_snippet":      result.RDFaSnippet,
+		"status_code":       result.StatusCode,
+		"content_type":      result.ContentType,
+		"resource_type":     result.ResourceType,
+		"content_length":    result.ContentLength,
+		"response_headers":  string(headers),
+		"content_hash":      result.ContentHash,
+	}
+}
+
+// pageKey returns the form of a URL used to identify a page within a site
+// crawl: the job's normalization, always without the fragment
+func pageKey(rawURL string, norm URLNormalization) string {
+	norm.KeepFragments = false
+	return normalizeURL(rawURL, norm)
+}
+
+// isCrawlable reports whether a URL can be fetched as a page
+func isCrawlable(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
 }
 
 // performCrawl performs the actual crawling operation
-func (cs *CrawlerService) performCrawl(targetURL string, cancelChan <-chan bool) (*CrawlResult, error) {
+func (cs *CrawlerService) performCrawl(targetURL string, state *crawlState) (*CrawlResult, error) {
 	// Check for cancellation
-	select {
-	case <-cancelChan:
+	if state.cancelled() {
 		return nil, fmt.Errorf("crawl cancelled")
-	default:
 	}
 
 	// Parse target URL
@@ -217,41 +740,81 @@ func (cs *CrawlerService) performCrawl(targetURL string, cancelChan <-chan bool)
 		return nil, fmt.Errorf("failed to parse URL: %v", err)
 	}
 
-	// Fetch the page
-	resp, err := cs.client.Get(targetURL)
+	// Honor robots.txt for the page itself
+	if allowed, rule := cs.robotsAllowed(state.ctx, targetURL); !allowed {
+		return nil, fmt.Errorf("blocked by robots.txt (%s)", rule)
+	}
+
+	// Fetch the page within the host's limits, recording any redirects on the way
+	resp, trace, err := cs.fetchPolitely(state, state.pageClient, "GET", targetURL, nil)
+	state.recordRedirect(trace, RedirectKindPage, "", err)
+	var blocked *blockedAddressError
+	if errors.As(err, &blocked) {
+		return nil, fmt.Errorf("refused to fetch URL: %w", blocked)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch URL: %v", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	// Relative links resolve against the page the redirects ended on, and
+	// the host the entry page ends on belongs to the site
+	baseURL = resp.Request.URL
+	if pageKey(targetURL, state.settings.Normalization) == pageKey(state.job.URL, state.settings.Normalization) {
+		state.scope.addRoot(baseURL.Hostname())
 	}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	// Read response body, closing it before the links are checked so the
+	// host's request slot is free for them
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	resp.Body.Close()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
 
+	// Any response is a result; its status and type decide what is extracted
+	result := &CrawlResult{
+		StatusCode:    resp.StatusCode,
+		ContentLength: resp.ContentLength,
+		Headers:       responseHeaders(resp.Header),
+	}
+	if result.ContentLength < 0 {
+		result.ContentLength = int64(len(body))
+	}
+	result.ContentType, result.ResourceType = classifyContent(resp.Header.Get("Content-Type"), body)
+
+	if result.ResourceType != ResourceHTML {
+		result.ContentHash = hashText(string(body))
+		return result, nil
+	}
+
 	// Parse HTML
 	doc, err := html.Parse(strings.NewReader(string(body)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse HTML: %v", err)
 	}
 
-	result := &CrawlResult{}
-
 	// Extract basic information
 	cs.extractBasicInfo(doc, result, string(body))
 
-	// Extract links and analyze them
-	links := cs.extractLinks(doc, baseURL)
-	cs.analyzeLinks(links, result, cancelChan)
+	// Hash the page text, and the regions the job monitors, for change detection
+	result.ContentHash = contentHash(doc)
+	result.RegionHashes = regionHashes(doc, state.job.MonitorSelectors)
+
+	// Extract links, and the page's assets if the job checks them, and
+	// analyze them. Links on error pages are not checked.
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		links := cs.extractLinks(doc, baseURL, state)
+		result.Links, _ = uniqueLinks(links)
+		var assets []LinkInfo
+		if deref(state.settings.CheckAssets) {
+			assets = cs.extractAssets(doc, baseURL, state)
+		}
+		cs.analyzeLinks(targetURL, append(links, assets...), result, state)
+	}
 
 	// Check for login form
 	result.HasLoginForm = cs.hasLoginForm(doc)
-	log.Printf("[DEBUG] result: %s", result)
+	log.Printf("[DEBUG] result: %+v", result)
 	return result, nil
 }
 
@@ -375,9 +938,15 @@ func (cs *CrawlerService) extractBasicInfo(doc *html.Node, result *CrawlResult,
 	result.HasJSONLD = hasJSONLD
 	result.HasMicrodata = hasMicrodata
 	result.HasRDFa = hasRDFa
-	if len(jsonldSnippet) > 500 { jsonldSnippet = jsonldSnippet[:500] + "..." }
-	if len(microdataSnippet) > 500 { microdataSnippet = microdataSnippet[:500] + "..." }
-	if len(rdfaSnippet) > 500 { rdfaSnippet = rdfaSnippet[:500] + "..." }
+	if len(jsonldSnippet) > 500 {
+		jsonldSnippet = jsonldSnippet[:500] + "..."
+	}
+	if len(microdataSnippet) > 500 {
+		microdataSnippet = microdataSnippet[:500] + "..."
+	}
+	if len(rdfaSnippet) > 500 {
+		rdfaSnippet = rdfaSnippet[:500] + "..."
+	}
 	result.JSONLDSnippet = jsonldSnippet
 	result.MicrodataSnippet = microdataSnippet
 	result.RDFaSnippet = rdfaSnippet
@@ -393,7 +962,7 @@ func (cs *CrawlerService) extractBasicInfo(doc *html.Node, result *CrawlResult,
 // detectHTMLVersion detects the HTML version from the document
 func (cs *CrawlerService) detectHTMLVersion(htmlContent string) string {
 	htmlContent = strings.ToLower(htmlContent)
-	
+
 	// Check for HTML5 doctype
 	if strings.Contains(htmlContent, "<!doctype html>") {
 		return "HTML5"
@@ -466,17 +1035,22 @@ func (cs *CrawlerService) extractTitleAndHeadings(doc *html.Node, result *CrawlR
 	})
 }
 
-// extractLinks extracts all links from the document
-func (cs *CrawlerService) extractLinks(doc *html.Node, baseURL *url.URL) []LinkInfo {
+// extractLinks extracts all links from the document, normalized as the job
+// configures
+func (cs *CrawlerService) extractLinks(doc *html.Node, baseURL *url.URL, state *crawlState) []LinkInfo {
 	var links []LinkInfo
-	
+
 	cs.traverseNode(doc, func(n *html.Node) {
 		if n.Type == html.ElementNode && n.Data == "a" {
 			for _, attr := range n.Attr {
 				if attr.Key == "href" {
 					if href := strings.TrimSpace(attr.Val); href != "" {
-						link := cs.processLink(href, baseURL)
+						link := cs.processLink(href, baseURL, state)
 						if link.URL != "" {
+							link.AnchorText = anchorText(n)
+							link.Element = "a"
+							link.Attribute = "href"
+							link.Rel = htmlAttr(n, "rel")
 							links = append(links, link)
 						}
 					}
@@ -485,12 +1059,13 @@ func (cs *CrawlerService) extractLinks(doc *html.Node, baseURL *url.URL) []LinkI
 			}
 		}
 	})
-	
+
 	return links
 }
 
-// processLink processes a single link and determines if it's internal or external
-func (cs *CrawlerService) processLink(href string, baseURL *url.URL) LinkInfo {
+// processLink processes a single link and determines if it's internal or
+// external to the job's site scope
+func (cs *CrawlerService) processLink(href string, baseURL *url.URL, state *crawlState) LinkInfo {
 	link := LinkInfo{
 		URL: href,
 	}
@@ -514,70 +1089,109 @@ func (cs *CrawlerService) processLink(href string, baseURL *url.URL) LinkInfo {
 		linkURL = baseURL.ResolveReference(linkURL)
 	}
 
+	linkURL = state.settings.Normalization.Normalize(linkURL)
 	link.URL = linkURL.String()
 
 	// Determine if link is internal or external
-	link.IsInternal = linkURL.Host == baseURL.Host
+	link.IsInternal = state.scope.Contains(linkURL.Hostname())
+
+	// The job's URL rules decide whether the link is followed and checked
+	link.Action = RuleFollow
+	if rule := state.rules.Match(link.URL); rule != nil {
+		if rule.Action == RuleIgnore {
+			return LinkInfo{} // Return empty link to skip
+		}
+		link.Action = rule.Action
+		link.Rule = rule.Pattern
+	}
 
 	return link
 }
 
-// analyzeLinks analyzes all links to check for broken links
-func (cs *CrawlerService) analyzeLinks(links []LinkInfo, result *CrawlResult, cancelChan <-chan bool) {
+// analyzeLinks analyzes all links and assets to check for broken ones. Only
+// anchors count towards the internal and external link totals.
+func (cs *CrawlerService) analyzeLinks(pageURL string, links []LinkInfo, result *CrawlResult, state *crawlState) {
 	internalCount := 0
 	externalCount := 0
-	var brokenLinks []BrokenLinkInfo
+	var brokenLinks, brokenAssets []BrokenLinkInfo
+
+	// Each normalized URL is counted and checked once per page, and reported
+	// once as a link and once per asset type; its first occurrence provides
+	// the source context
+	unique, occurrences := uniqueLinks(links)
+	for _, link := range unique {
+		if link.AssetType != "" {
+			continue
+		}
+		if link.IsInternal {
+			internalCount++
+		} else {
+			externalCount++
+		}
+	}
 
 	// Use a semaphore to limit concurrent requests
-	semaphore := make(chan struct{}, 10)
+	semaphore := make(chan struct{}, deref(state.settings.LinkConcurrency))
 	var wg sync.WaitGroup
 	var mu sync.Mutex
+	checked := 0
 
-	for _, link := range links {
+	for _, link := range unique {
 		// Check for cancellation
-		select {
-		case <-cancelChan:
-			return
-		default:
+		if state.cancelled() {
+			break
 		}
 
-		if link.IsInternal {
-			internalCount++
-		} else {
-			externalCount++
+		// Links are only counted when the job turns their checks off
+		if deref(state.settings.SkipLinkChecks) || (deref(state.settings.SkipExternalChecks) && !link.IsInternal) {
+			continue
 		}
 
 		// Check link status in goroutine
 		wg.Add(1)
 		go func(l LinkInfo) {
 			defer wg.Done()
-			
+
 			// Acquire semaphore
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
 			// Check for cancellation
-			select {
-			case <-cancelChan:
+			if state.cancelled() {
 				return
-			default:
 			}
 
-			statusCode, err := cs.checkLinkStatus(l.URL)
-			
+			// Links disallowed by robots.txt are recorded instead of checked
+			if allowed, rule := cs.robotsAllowed(state.ctx, l.URL); !allowed {
+				state.skipLink(SkippedLinkInfo{URL: l.URL, PageURL: pageURL, Reason: "robots", Detail: rule})
+				return
+			}
+
+			// So are links a URL rule excludes from checking
+			if l.Action == RuleSkipCheck {
+				state.skipLink(SkippedLinkInfo{URL: l.URL, PageURL: pageURL, Reason: "rule", Detail: l.Rule})
+				return
+			}
+
+			info, broken := state.checkLink(l.URL, func(linkURL string) BrokenLinkInfo {
+				return cs.checkLinkCached(state, linkURL, pageURL)
+			})
+
+			info.AnchorText = l.AnchorText
+			info.Element = l.Element
+			info.Attribute = l.Attribute
+			info.Rel = l.Rel
+			info.AssetType = l.AssetType
+			info.Occurrences = occurrences[linkKey(l)]
+
 			mu.Lock()
 			defer mu.Unlock()
-			
-			if err != nil || statusCode >= 400 {
-				errorMsg := ""
-				if err != nil {
-					errorMsg = err.Error()
-				}
-				brokenLinks = append(brokenLinks, BrokenLinkInfo{
-					URL:        l.URL,
-					StatusCode: statusCode,
-					Error:      errorMsg,
-				})
+			checked++
+			notifyJobProgress(state.job, "links", checked, len(unique))
+			if broken && l.AssetType != "" {
+				brokenAssets = append(brokenAssets, info)
+			} else if broken {
+				brokenLinks = append(brokenLinks, info)
 			}
 		}(link)
 	}
@@ -587,47 +1201,14 @@ func (cs *CrawlerService) analyzeLinks(links []LinkInfo, result *CrawlResult, ca
 	result.InternalLinks = internalCount
 	result.ExternalLinks = externalCount
 	result.BrokenLinks = brokenLinks
-}
-
-// checkLinkStatus checks the HTTP status of a link
-func (cs *CrawlerService) checkLinkStatus(linkURL string) (int, error) {
-	req, err := http.NewRequest("HEAD", linkURL, nil)
-	if err != nil {
-		return 0, err
-	}
-
-	// Set a reasonable timeout for link checking
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        10,
-			MaxIdleConnsPerHost: 2,
-			IdleConnTimeout:     30 * time.Second,
-		},
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		// If HEAD fails, try GET
-		req, err = http.NewRequest("GET", linkURL, nil)
-		if err != nil {
-			return 0, err
-		}
-		resp, err = client.Do(req)
-		if err != nil {
-			return 0, err
-		}
-	}
-	defer resp.Body.Close()
-
-	return resp.StatusCode, nil
+	result.BrokenAssets = brokenAssets
 }
 
 // hasLoginForm checks if the page contains a login form
 func (cs *CrawlerService) hasLoginForm(doc *html.Node) bool {
 	hasPasswordField := false
 	hasSubmitButton := false
-	
+
 	cs.traverseNode(doc, func(n *html.Node) {
 		if n.Type == html.ElementNode {
 			switch n.Data {
@@ -650,8 +1231,8 @@ func (cs *CrawlerService) hasLoginForm(doc *html.Node) bool {
 				// Also check button text content for login-related keywords
 				if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
 					text := strings.ToLower(strings.TrimSpace(n.FirstChild.Data))
-					if strings.Contains(text, "login") || 
-						strings.Contains(text, "sign in") || 
+					if strings.Contains(text, "login") ||
+						strings.Contains(text, "sign in") ||
 						strings.Contains(text, "log in") ||
 						strings.Contains(text, "submit") {
 						hasSubmitButton = true
@@ -695,35 +1276,9 @@ func (cs *CrawlerService) traverseNode(n *html.Node, fn func(*html.Node)) {
 	}
 }
 
-// Add helper to extract links for a given URL (re-crawl the page)
-func (cs *CrawlerService) extractLinksFromURL(targetURL string) []LinkInfo {
-	// Fetch the page
-	resp, err := cs.client.Get(targetURL)
-	if err != nil {
-		return nil
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil
-	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil
-	}
-	doc, err := html.Parse(strings.NewReader(string(body)))
-	if err != nil {
-		return nil
-	}
-	baseURL, err := url.Parse(targetURL)
-	if err != nil {
-		return nil
-	}
-	return cs.extractLinks(doc, baseURL)
-}
-
 // Add helper to render a node as HTML snippet
 func renderNodeSnippet(n *html.Node) string {
 	var b strings.Builder
 	html.Render(&b, n)
 	return b.String()
-}
\ No newline at end of file
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldChange is a result field whose value differs between two runs
+type FieldChange struct {
+	Field      string      `json:"field"`
+	From       interface{} `json:"from"`
+	To         interface{} `json:"to"`
+	Regression bool        `json:"regression"`
+}
+
+// RunDiff describes what changed from one run of a job to another
+type RunDiff struct {
+	From                 *CrawlRun      `json:"from"`
+	To                   *CrawlRun      `json:"to"`
+	Changes              []FieldChange  `json:"changes"`
+	NewBrokenLinks       []BrokenLink   `json:"new_broken_links"`
+	FixedBrokenLinks     []BrokenLink   `json:"fixed_broken_links"`
+	AddedInternalLinks   []InternalLink `json:"added_internal_links"`
+	RemovedInternalLinks []InternalLink `json:"removed_internal_links"`
+	Regressions          int            `json:"regressions"`
+}
+
+// diffRuns compares the results and links of two runs
+func diffRuns(from, to *CrawlRun) *RunDiff {
+	diff := &RunDiff{
+		From:                 from,
+		To:                   to,
+		Changes:              []FieldChange{},
+		NewBrokenLinks:       []BrokenLink{},
+		FixedBrokenLinks:     []BrokenLink{},
+		AddedInternalLinks:   []InternalLink{},
+		RemovedInternalLinks: []InternalLink{},
+	}
+
+	// Text fields regress when a value that was present disappears
+	texts := []struct {
+		field    string
+		from, to string
+	}{
+		{"page_title", from.PageTitle, to.PageTitle},
+		{"meta_title", from.MetaTitle, to.MetaTitle},
+		{"meta_description", from.MetaDescription, to.MetaDescription},
+		{"canonical", from.Canonical, to.Canonical},
+		{"html_version", from.HTMLVersion, to.HTMLVersion},
+		{"content_type", from.ContentType, to.ContentType},
+		{"content_hash", from.ContentHash, to.ContentHash},
+	}
+	for _, t := range texts {
+		if t.from != t.to {
+			diff.add(FieldChange{Field: t.field, From: t.from, To: t.to, Regression: t.from != "" && t.to == ""})
+		}
+	}
+
+	// Losing every heading of a level that was present is a regression
+	counts := []struct {
+		field    string
+		from, to int
+		heading  bool
+	}{
+		{"h1_count", from.H1Count, to.H1Count, true},
+		{"h2_count", from.H2Count, to.H2Count, true},
+		{"h3_count", from.H3Count, to.H3Count, true},
+		{"h4_count", from.H4Count, to.H4Count, true},
+		{"h5_count", from.H5Count, to.H5Count, true},
+		{"h6_count", from.H6Count, to.H6Count, true},
+		{"internal_links", from.InternalLinks, to.InternalLinks, false},
+		{"external_links", from.ExternalLinks, to.ExternalLinks, false},
+	}
+	for _, c := range counts {
+		if c.from != c.to {
+			diff.add(FieldChange{Field: c.field, From: c.from, To: c.to, Regression: c.heading && c.from > 0 && c.to == 0})
+		}
+	}
+
+	// A page that starts responding with an error status has regressed
+	if from.StatusCode != to.StatusCode {
+		diff.add(FieldChange{Field: "status_code", From: from.StatusCode, To: to.StatusCode, Regression: from.StatusCode < 400 && to.StatusCode >= 400})
+	}
+
+	// Structured data regresses when a format that was present is gone
+	flags := []struct {
+		field      string
+		from, to   bool
+		structured bool
+	}{
+		{"has_jsonld", from.HasJSONLD, to.HasJSONLD, true},
+		{"has_microdata", from.HasMicrodata, to.HasMicrodata, true},
+		{"has_rdfa", from.HasRDFa, to.HasRDFa, true},
+		{"has_login_form", from.HasLoginForm, to.HasLoginForm, false},
+	}
+	for _, f := range flags {
+		if f.from != f.to {
+			diff.add(FieldChange{Field: f.field, From: f.from, To: f.to, Regression: f.structured && f.from})
+		}
+	}
+
+	if from.Status != to.Status {
+		diff.add(FieldChange{Field: "status", From: from.Status, To: to.Status, Regression: from.Status == "completed"})
+	}
+
+	// Broken links and assets are matched by URL, asset type and the page
+	// they were found on
+	var fromBroken, toBroken []BrokenLink
+	db.Where("crawl_run_id = ?", from.ID).Find(&fromBroken)
+	db.Where("crawl_run_id = ?", to.ID).Find(&toBroken)
+
+	brokenKey := func(l BrokenLink) string { return l.PageURL + "\n" + l.AssetType + "\n" + l.URL }
+	fromBrokenSet := make(map[string]bool, len(fromBroken))
+	for _, l := range fromBroken {
+		fromBrokenSet[brokenKey(l)] = true
+	}
+	toBrokenSet := make(map[string]bool, len(toBroken))
+	for _, l := range toBroken {
+		toBrokenSet[brokenKey(l)] = true
+		if !fromBrokenSet[brokenKey(l)] {
+			diff.NewBrokenLinks = append(diff.NewBrokenLinks, l)
+		}
+	}
+	for _, l := range fromBroken {
+		if !toBrokenSet[brokenKey(l)] {
+			diff.FixedBrokenLinks = append(diff.FixedBrokenLinks, l)
+		}
+	}
+	diff.Regressions += len(diff.NewBrokenLinks)
+
+	// Internal links are matched by source and target page
+	var fromLinks, toLinks []InternalLink
+	db.Where("crawl_run_id = ?", from.ID).Find(&fromLinks)
+	db.Where("crawl_run_id = ?", to.ID).Find(&toLinks)
+
+	linkKey := func(l InternalLink) string { return l.FromURL + "\n" + l.ToURL }
+	fromLinkSet := make(map[string]bool, len(fromLinks))
+	for _, l := range fromLinks {
+		fromLinkSet[linkKey(l)] = true
+	}
+	toLinkSet := make(map[string]bool, len(toLinks))
+	for _, l := range toLinks {
+		key := linkKey(l)
+		if !fromLinkSet[key] && !toLinkSet[key] {
+			diff.AddedInternalLinks = append(diff.AddedInternalLinks, l)
+		}
+		toLinkSet[key] = true
+	}
+	removed := make(map[string]bool)
+	for _, l := range fromLinks {
+		key := linkKey(l)
+		if !toLinkSet[key] && !removed[key] {
+			diff.RemovedInternalLinks = append(diff.RemovedInternalLinks, l)
+			removed[key] = true
+		}
+	}
+
+	return diff
+}
+
+func (d *RunDiff) add(change FieldChange) {
+	d.Changes = append(d.Changes, change)
+	if change.Regression {
+		d.Regressions++
+	}
+}
+
+// getCrawlRunDiff compares two runs of a job. Without from and to, the latest
+// completed run is compared with the completed run before it.
+func getCrawlRunDiff(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var job CrawlJob
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	var to CrawlRun
+	if id := c.Query("to"); id != "" {
+		if err := db.Where("id = ? AND crawl_job_id = ?", id, job.ID).First(&to).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Run not found"})
+			return
+		}
+	} else if err := db.Where("crawl_job_id = ? AND status = ?", job.ID, "completed").Order("run_number desc").First(&to).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job has no completed runs"})
+		return
+	}
+
+	var from CrawlRun
+	if id := c.Query("from"); id != "" {
+		if err := db.Where("id = ? AND crawl_job_id = ?", id, job.ID).First(&from).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Run not found"})
+			return
+		}
+	} else if err := db.Where("crawl_job_id = ? AND status = ? AND run_number < ?", job.ID, "completed", to.RunNumber).Order("run_number desc").First(&from).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No earlier completed run to compare with"})
+		return
+	}
+
+	if from.ID == to.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot compare a run with itself"})
+		return
+	}
+
+	c.JSON(http.StatusOK, diffRuns(&from, &to))
+}
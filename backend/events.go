@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job event types
+const (
+	EventQueued    = "queued"
+	EventRunning   = "running"
+	EventProgress  = "progress"
+	EventCompleted = "completed"
+	EventError     = "error"
+	EventStopped   = "stopped"
+	EventDequeued  = "dequeued" // removed from the queue before it ran
+	EventAlert     = "alert"
+)
+
+// Buffered events per subscriber; a client that falls further behind misses events
+const eventBufferSize = 256
+
+// JobEvent is a crawl job lifecycle or progress event
+type JobEvent struct {
+	Type    string    `json:"type"`
+	JobID   uint      `json:"job_id"`
+	UserID  uint      `json:"-"`
+	URL     string    `json:"url"`
+	Status  string    `json:"status"`
+	Phase   string    `json:"phase,omitempty"` // links, pages
+	Done    int       `json:"done,omitempty"`
+	Total   int       `json:"total,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Alert   *Alert    `json:"alert,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// EventBroker fans job events out to subscribed clients
+type EventBroker struct {
+	mutex       sync.RWMutex
+	subscribers map[*eventSubscriber]struct{}
+	closed      bool
+}
+
+type eventSubscriber struct {
+	userID uint
+	jobID  uint // 0 for all of the user's jobs
+	events chan JobEvent
+}
+
+// NewEventBroker creates an event broker with no subscribers
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[*eventSubscriber]struct{})}
+}
+
+// Subscribe registers for events on a user's jobs, or on one job if jobID is set
+func (b *EventBroker) Subscribe(userID, jobID uint) *eventSubscriber {
+	sub := &eventSubscriber{
+		userID: userID,
+		jobID:  jobID,
+		events: make(chan JobEvent, eventBufferSize),
+	}
+	b.mutex.Lock()
+	if b.closed {
+		close(sub.events)
+	} else {
+		b.subscribers[sub] = struct{}{}
+	}
+	b.mutex.Unlock()
+	return sub
+}
+
+// Unsubscribe removes a subscriber
+func (b *EventBroker) Unsubscribe(sub *eventSubscriber) {
+	b.mutex.Lock()
+	if _, exists := b.subscribers[sub]; exists {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+	b.mutex.Unlock()
+}
+
+// Publish delivers an event to every matching subscriber without blocking
+func (b *EventBroker) Publish(event JobEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for sub := range b.subscribers {
+		if sub.userID != event.UserID || (sub.jobID != 0 && sub.jobID != event.JobID) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+// Close ends every subscription, letting open streams finish
+func (b *EventBroker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// notifyJobStatus announces that a job moved to a new status
+func notifyJobStatus(job *CrawlJob, status, message string) {
+	event := JobEvent{
+		Type:    status,
+		JobID:   job.ID,
+		UserID:  job.UserID,
+		URL:     job.URL,
+		Status:  status,
+		Message: message,
+	}
+	if status == EventDequeued {
+		event.Status = "stopped"
+	}
+	eventBroker.Publish(event)
+
+	// Jobs removed from the queue never ran, so webhooks are not told
+	switch status {
+	case EventCompleted, EventError, EventStopped:
+		webhookDispatcher.JobFinished(job, status)
+	}
+}
+
+// notifyAlert announces a triggered alert to streaming clients and webhooks
+func notifyAlert(alert *Alert) {
+	eventBroker.Publish(JobEvent{
+		Type:    EventAlert,
+		JobID:   alert.CrawlJobID,
+		UserID:  alert.UserID,
+		URL:     alert.URL,
+		Message: alert.Message,
+		Alert:   alert,
+	})
+	webhookDispatcher.AlertTriggered(alert)
+}
+
+// notifyJobProgress announces progress through a phase of a running job
+func notifyJobProgress(job *CrawlJob, phase string, done, total int) {
+	eventBroker.Publish(JobEvent{
+		Type:   EventProgress,
+		JobID:  job.ID,
+		UserID: job.UserID,
+		URL:    job.URL,
+		Status: "running",
+		Phase:  phase,
+		Done:   done,
+		Total:  total,
+	})
+}
+
+// streamJobEvents streams job events as Server-Sent Events, for all of the
+// user's jobs or for the job given by the :id parameter or job_id query
+func streamJobEvents(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	rawJobID := c.Param("id")
+	if rawJobID == "" {
+		rawJobID = c.Query("job_id")
+	}
+
+	var jobID uint
+	var job CrawlJob
+	if rawJobID != "" {
+		parsed, err := strconv.ParseUint(rawJobID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+			return
+		}
+		if err := db.Where("id = ? AND user_id = ?", parsed, userID).First(&job).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		jobID = job.ID
+	}
+
+	sub := eventBroker.Subscribe(userID, jobID)
+	defer eventBroker.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// Start a single-job stream with the job's current state
+	if jobID != 0 {
+		writeJobEvent(c.Writer, JobEvent{
+			Type:    job.Status,
+			JobID:   job.ID,
+			URL:     job.URL,
+			Status:  job.Status,
+			Message: job.ErrorMessage,
+			Time:    time.Now(),
+		})
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return false
+			}
+			writeJobEvent(w, event)
+			return true
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// writeJobEvent writes one event in text/event-stream format
+func writeJobEvent(w io.Writer, event JobEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
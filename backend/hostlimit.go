@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Upper bounds on the per-host limits a job may set
+	maxHostConcurrency = 20
+	maxHostIntervalMS  = 60000
+
+	// Longest Retry-After honored; hosts asking for more are retried after this
+	maxRetryAfter = 2 * time.Minute
+	// Wait applied to a 429 response without a Retry-After header
+	defaultRetryAfter = 5 * time.Second
+	// Times a request is retried after a 429 or 503 with Retry-After
+	maxRetryAfterRetries = 2
+)
+
+// HostLimits bounds the requests sent to a single host
+type HostLimits struct {
+	// Requests in flight at once
+	MaxConcurrent int
+	// Minimum time between the starts of two requests
+	MinInterval time.Duration
+}
+
+// HostLimiter enforces per-host concurrency and request-rate limits across
+// every job, and holds requests back while a host has asked us to retry later
+type HostLimiter struct {
+	mutex    sync.Mutex
+	hosts    map[string]*hostSlot
+	defaults HostLimits
+}
+
+type hostSlot struct {
+	active       int
+	next         time.Time
+	blockedUntil time.Time
+	// Closed and replaced whenever a request to the host finishes
+	released chan struct{}
+}
+
+// NewHostLimiter creates a limiter applying the given limits to jobs that do
+// not set their own
+func NewHostLimiter(defaults HostLimits) *HostLimiter {
+	return &HostLimiter{
+		hosts:    make(map[string]*hostSlot),
+		defaults: defaults,
+	}
+}
+
+// Limits returns the limits for a crawl's settings, using the defaults for any
+// they do not set. A robots.txt Crawl-delay longer than the interval takes
+// precedence.
+func (hl *HostLimiter) Limits(settings CrawlSettings, crawlDelay time.Duration) HostLimits {
+	limits := hl.defaults
+	if n := deref(settings.HostConcurrency); n > 0 {
+		limits.MaxConcurrent = n
+	}
+	if ms := deref(settings.HostIntervalMS); ms > 0 {
+		limits.MinInterval = time.Duration(ms) * time.Millisecond
+	}
+	if crawlDelay > limits.MinInterval {
+		limits.MinInterval = crawlDelay
+	}
+	return limits
+}
+
+// Acquire blocks until a request to host may start under the limits, and
+// returns a function that must be called once the request has finished
+func (hl *HostLimiter) Acquire(ctx context.Context, host string, limits HostLimits) (func(), error) {
+	for {
+		hl.mutex.Lock()
+		slot := hl.slot(host)
+		if slot.active < limits.MaxConcurrent {
+			// Reserve the next start time so waiting requests are spaced out
+			start := latest(time.Now(), slot.next, slot.blockedUntil)
+			slot.next = start.Add(limits.MinInterval)
+			slot.active++
+			hl.mutex.Unlock()
+
+			release := func() { hl.release(host) }
+			if err := hl.waitUntilAllowed(ctx, host, start); err != nil {
+				release()
+				return nil, err
+			}
+			return release, nil
+		}
+		released := slot.released
+		hl.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// waitUntilAllowed sleeps until start, and for longer if the host asks us to
+// back off in the meantime
+func (hl *HostLimiter) waitUntilAllowed(ctx context.Context, host string, start time.Time) error {
+	for {
+		if err := sleepContext(ctx, time.Until(start)); err != nil {
+			return err
+		}
+		hl.mutex.Lock()
+		blockedUntil := hl.hosts[host].blockedUntil
+		hl.mutex.Unlock()
+		if !time.Now().Before(blockedUntil) {
+			return nil
+		}
+		start = blockedUntil
+	}
+}
+
+// Backoff holds back requests to host for the given duration
+func (hl *HostLimiter) Backoff(host string, wait time.Duration) {
+	hl.mutex.Lock()
+	defer hl.mutex.Unlock()
+	slot := hl.slot(host)
+	if until := time.Now().Add(wait); until.After(slot.blockedUntil) {
+		slot.blockedUntil = until
+	}
+}
+
+func (hl *HostLimiter) release(host string) {
+	hl.mutex.Lock()
+	defer hl.mutex.Unlock()
+	slot := hl.hosts[host]
+	slot.active--
+	close(slot.released)
+	slot.released = make(chan struct{})
+
+	// Forget idle hosts once their limits no longer affect the next request
+	now := time.Now()
+	if slot.active == 0 && now.After(slot.next) && now.After(slot.blockedUntil) {
+		delete(hl.hosts, host)
+	}
+}
+
+// slot returns the state of a host; the caller must hold the mutex
+func (hl *HostLimiter) slot(host string) *hostSlot {
+	slot, ok := hl.hosts[host]
+	if !ok {
+		slot = &hostSlot{released: make(chan struct{})}
+		hl.hosts[host] = slot
+	}
+	return slot
+}
+
+// fetchPolitely fetches a URL within the job's per-host limits, following
+// redirects as its settings allow. When the host answers 429 or 503 with
+// Retry-After, every request to it is held back for that long and the request
+// is retried. Redirect hops to other hosts are not limited separately. Extra
+// headers are sent in addition to the crawl's User-Agent.
+func (cs *CrawlerService) fetchPolitely(state *crawlState, client *http.Client, method, rawURL string, extra http.Header) (*http.Response, *redirectTrace, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, &redirectTrace{}, err
+	}
+	var crawlDelay time.Duration
+	if cs.respectRobots {
+		crawlDelay = cs.robots.Rules(state.ctx, u).CrawlDelay
+	}
+	limits := cs.hosts.Limits(state.settings, crawlDelay)
+	header := cs.requestHeader(state, extra)
+
+	for attempt := 0; ; attempt++ {
+		release, err := cs.hosts.Acquire(state.ctx, u.Host, limits)
+		if err != nil {
+			return nil, &redirectTrace{}, err
+		}
+		resp, trace, err := fetchWithRedirects(state.ctx, client, method, rawURL, header, state.settings.redirectPolicy())
+		if err != nil {
+			release()
+			return nil, trace, err
+		}
+
+		wait, retry := retryAfter(resp)
+		if !retry {
+			// The host's slot is held until the body has been read
+			resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+			return resp, trace, nil
+		}
+		cs.hosts.Backoff(u.Host, wait)
+		if attempt >= maxRetryAfterRetries {
+			resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+			return resp, trace, nil
+		}
+		resp.Body.Close()
+		release()
+	}
+}
+
+// requestHeader returns the headers a crawl sends to every host: its
+// User-Agent and any extra headers of the request. The crawl's custom headers
+// are added by its session for hosts in scope only.
+func (cs *CrawlerService) requestHeader(state *crawlState, extra http.Header) http.Header {
+	header := http.Header{"User-Agent": {cs.userAgent}}
+	if state.settings.UserAgent != "" {
+		header.Set("User-Agent", state.settings.UserAgent)
+	}
+	for key, values := range extra {
+		header[key] = values
+	}
+	return header
+}
+
+// retryAfter reports how long a host asked us to wait, for 429 and 503
+// responses. Retry-After may be given in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = time.Until(date)
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		wait = defaultRetryAfter
+	} else {
+		return 0, false
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	return wait, true
+}
+
+// releasingBody releases a host slot when the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+func latest(times ...time.Time) time.Time {
+	var last time.Time
+	for _, t := range times {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// sleepContext sleeps for d, returning early with an error if ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
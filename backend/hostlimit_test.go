@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"seconds on 429", http.StatusTooManyRequests, "3", 3 * time.Second, true},
+		{"seconds on 503", http.StatusServiceUnavailable, "10", 10 * time.Second, true},
+		{"zero seconds", http.StatusTooManyRequests, "0", 0, true},
+		{"capped", http.StatusTooManyRequests, "86400", maxRetryAfter, true},
+		{"date in the past", http.StatusTooManyRequests, "Mon, 02 Jan 2006 15:04:05 GMT", 0, true},
+		{"missing on 429", http.StatusTooManyRequests, "", defaultRetryAfter, true},
+		{"invalid on 429", http.StatusTooManyRequests, "soon", defaultRetryAfter, true},
+		{"negative on 429", http.StatusTooManyRequests, "-5", defaultRetryAfter, true},
+		{"missing on 503", http.StatusServiceUnavailable, "", 0, false},
+		{"other status", http.StatusInternalServerError, "5", 0, false},
+		{"success", http.StatusOK, "5", 0, false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		got, ok := retryAfter(resp)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: retryAfter = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	resp.Header.Set("Retry-After", time.Now().Add(30*time.Second).UTC().Format(http.TimeFormat))
+
+	got, ok := retryAfter(resp)
+	if !ok {
+		t.Fatal("retryAfter did not accept an HTTP date")
+	}
+	// HTTP dates have a resolution of one second
+	if got < 28*time.Second || got > 30*time.Second {
+		t.Errorf("retryAfter = %v, want about 30s", got)
+	}
+}
@@ -34,6 +34,7 @@ CREATE TABLE IF NOT EXISTS crawl_jobs (
     internal_links INT DEFAULT 0,
     external_links INT DEFAULT 0,
     broken_links INT DEFAULT 0,
+    broken_assets INT DEFAULT 0,
     inbound_internal_links INT DEFAULT 0,
     is_orphan BOOLEAN DEFAULT FALSE,
     has_login_form BOOLEAN DEFAULT FALSE,
@@ -44,9 +45,52 @@ CREATE TABLE IF NOT EXISTS crawl_jobs (
     microdata_snippet TEXT DEFAULT NULL,
     rdfa_snippet TEXT DEFAULT NULL,
     error_message TEXT DEFAULT '',
+    error_code VARCHAR(30) DEFAULT '',
     meta_title TEXT DEFAULT '',
     meta_description TEXT DEFAULT '',
     canonical TEXT DEFAULT '',
+    mode VARCHAR(20) DEFAULT 'page',
+    max_depth INT DEFAULT 0,
+    max_pages INT DEFAULT 1,
+    profile_id INT NULL,
+    user_agent VARCHAR(255) DEFAULT '',
+    page_timeout_seconds INT NULL,
+    link_timeout_seconds INT NULL,
+    link_concurrency INT NULL,
+    host_concurrency INT NULL,
+    host_interval_ms INT NULL,
+    redirect_policy VARCHAR(20) DEFAULT '',
+    max_redirects INT NULL,
+    headers TEXT,
+    cookies TEXT,
+    auth_type VARCHAR(20) DEFAULT '',
+    credentials TEXT,
+    scope TEXT,
+    normalization TEXT,
+    url_rules TEXT,
+    skip_link_checks BOOLEAN NULL,
+    skip_external_checks BOOLEAN NULL,
+    check_assets BOOLEAN NULL,
+    bypass_link_cache BOOLEAN NULL,
+    fresh_link_checks BOOLEAN DEFAULT FALSE,
+    pages_crawled INT DEFAULT 0,
+    skipped_links INT DEFAULT 0,
+    queued_at TIMESTAMP NULL,
+    worker_id VARCHAR(100) DEFAULT '',
+    heartbeat_at TIMESTAMP NULL,
+    attempts INT DEFAULT 0,
+    current_run_id INT NULL,
+    triggered_by VARCHAR(20) DEFAULT '',
+    status_code INT DEFAULT 0,
+    content_type VARCHAR(255) DEFAULT '',
+    resource_type VARCHAR(20) DEFAULT '',
+    content_length BIGINT DEFAULT 0,
+    response_headers TEXT,
+    content_hash VARCHAR(64) DEFAULT '',
+    content_changed BOOLEAN DEFAULT FALSE,
+    monitor_selectors TEXT,
+    region_hashes TEXT,
+    changed_regions TEXT,
     started_at TIMESTAMP NULL,
     completed_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -55,33 +99,357 @@ CREATE TABLE IF NOT EXISTS crawl_jobs (
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
     INDEX idx_user_id (user_id),
     INDEX idx_status (status),
-    INDEX idx_created_at (created_at)
+    INDEX idx_created_at (created_at),
+    INDEX idx_queued_at (queued_at)
+);
+
+-- Crawl runs table
+CREATE TABLE IF NOT EXISTS crawl_runs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    crawl_job_id INT NOT NULL,
+    user_id INT NOT NULL,
+    run_number INT NOT NULL,
+    triggered_by VARCHAR(20) DEFAULT '',
+    attempt INT DEFAULT 0,
+    url TEXT NOT NULL,
+    mode VARCHAR(20) DEFAULT '',
+    max_depth INT DEFAULT 0,
+    max_pages INT DEFAULT 0,
+    fresh_link_checks BOOLEAN DEFAULT FALSE,
+    check_assets BOOLEAN DEFAULT FALSE,
+    profile_id INT NULL,
+    settings TEXT,
+    auth_type VARCHAR(20) DEFAULT '',
+    status VARCHAR(20) DEFAULT '',
+    error_message TEXT,
+    error_code VARCHAR(30) DEFAULT '',
+    started_at TIMESTAMP NULL,
+    completed_at TIMESTAMP NULL,
+    html_version VARCHAR(50) DEFAULT '',
+    page_title TEXT,
+    h1_count INT DEFAULT 0,
+    h2_count INT DEFAULT 0,
+    h3_count INT DEFAULT 0,
+    h4_count INT DEFAULT 0,
+    h5_count INT DEFAULT 0,
+    h6_count INT DEFAULT 0,
+    internal_links INT DEFAULT 0,
+    external_links INT DEFAULT 0,
+    broken_links INT DEFAULT 0,
+    broken_assets INT DEFAULT 0,
+    has_login_form BOOLEAN DEFAULT FALSE,
+    meta_title TEXT,
+    meta_description TEXT,
+    canonical TEXT,
+    has_jsonld BOOLEAN DEFAULT FALSE,
+    has_microdata BOOLEAN DEFAULT FALSE,
+    has_rdfa BOOLEAN DEFAULT FALSE,
+    jsonld_snippet TEXT,
+    microdata_snippet TEXT,
+    rdfa_snippet TEXT,
+    pages_crawled INT DEFAULT 0,
+    skipped_links INT DEFAULT 0,
+    status_code INT DEFAULT 0,
+    content_type VARCHAR(255) DEFAULT '',
+    resource_type VARCHAR(20) DEFAULT '',
+    content_length BIGINT DEFAULT 0,
+    response_headers TEXT,
+    content_hash VARCHAR(64) DEFAULT '',
+    content_changed BOOLEAN DEFAULT FALSE,
+    region_hashes TEXT,
+    changed_regions TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (crawl_job_id) REFERENCES crawl_jobs(id) ON DELETE CASCADE,
+    UNIQUE INDEX idx_crawl_runs_job_run (crawl_job_id, run_number),
+    INDEX idx_crawl_runs_user_id (user_id),
+    INDEX idx_crawl_runs_status (status)
 );
 
 -- Broken links table
 CREATE TABLE IF NOT EXISTS broken_links (
     id INT AUTO_INCREMENT PRIMARY KEY,
     crawl_job_id INT NOT NULL,
+    crawl_run_id INT DEFAULT 0,
     url TEXT NOT NULL,
     status_code INT NOT NULL,
+    error_code VARCHAR(30) DEFAULT '',
+    error_message TEXT,
+    cached BOOLEAN DEFAULT FALSE,
+    page_url TEXT,
+    anchor_text TEXT,
+    element VARCHAR(20) DEFAULT '',
+    attribute VARCHAR(20) DEFAULT '',
+    rel VARCHAR(255) DEFAULT '',
+    occurrences INT DEFAULT 1,
+    asset_type VARCHAR(20) DEFAULT '',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP NULL,
     FOREIGN KEY (crawl_job_id) REFERENCES crawl_jobs(id) ON DELETE CASCADE,
-    INDEX idx_crawl_job_id (crawl_job_id)
+    INDEX idx_crawl_job_id (crawl_job_id),
+    INDEX idx_broken_links_run_id (crawl_run_id)
 );
 
 -- Internal links table
 CREATE TABLE IF NOT EXISTS internal_links (
     id INT AUTO_INCREMENT PRIMARY KEY,
     from_job_id INT NOT NULL,
+    crawl_run_id INT DEFAULT 0,
+    from_url TEXT,
     to_url TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (from_job_id) REFERENCES crawl_jobs(id) ON DELETE CASCADE,
     INDEX idx_from_job_id (from_job_id),
+    INDEX idx_internal_links_run_id (crawl_run_id),
     INDEX idx_to_url (to_url(255))
 );
 
+-- Site crawl seed URLs table
+CREATE TABLE IF NOT EXISTS crawl_seeds (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    crawl_job_id INT NOT NULL,
+    url TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (crawl_job_id) REFERENCES crawl_jobs(id) ON DELETE CASCADE,
+    INDEX idx_crawl_seeds_job_id (crawl_job_id)
+);
+
+-- Skipped links table
+CREATE TABLE IF NOT EXISTS skipped_links (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    crawl_job_id INT NOT NULL,
+    crawl_run_id INT DEFAULT 0,
+    url TEXT NOT NULL,
+    page_url TEXT,
+    reason VARCHAR(50) DEFAULT '',
+    detail TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (crawl_job_id) REFERENCES crawl_jobs(id) ON DELETE CASCADE,
+    INDEX idx_skipped_links_job_id (crawl_job_id),
+    INDEX idx_skipped_links_run_id (crawl_run_id)
+);
+
+-- Link status cache table
+CREATE TABLE IF NOT EXISTS link_status_caches (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    url_hash CHAR(64) NOT NULL,
+    url TEXT NOT NULL,
+    status_code INT DEFAULT 0,
+    error_code VARCHAR(30) DEFAULT '',
+    error TEXT,
+    checked_at TIMESTAMP NULL,
+    expires_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    UNIQUE INDEX idx_link_status_caches_url_hash (url_hash),
+    INDEX idx_link_status_caches_expires_at (expires_at)
+);
+
+-- Redirect chains table
+CREATE TABLE IF NOT EXISTS redirect_chains (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    crawl_job_id INT NOT NULL,
+    crawl_run_id INT DEFAULT 0,
+    kind VARCHAR(10) DEFAULT '',
+    url TEXT NOT NULL,
+    page_url TEXT,
+    final_url TEXT,
+    final_status INT DEFAULT 0,
+    redirects INT DEFAULT 0,
+    hops TEXT,
+    duration_ms BIGINT DEFAULT 0,
+    long_chain BOOLEAN DEFAULT FALSE,
+    has_loop BOOLEAN DEFAULT FALSE,
+    temporary BOOLEAN DEFAULT FALSE,
+    cross_host BOOLEAN DEFAULT FALSE,
+    https_upgrade BOOLEAN DEFAULT FALSE,
+    error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (crawl_job_id) REFERENCES crawl_jobs(id) ON DELETE CASCADE,
+    INDEX idx_redirect_chains_job_id (crawl_job_id),
+    INDEX idx_redirect_chains_run_id (crawl_run_id)
+);
+
+-- Site crawl pages table
+CREATE TABLE IF NOT EXISTS crawl_pages (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    crawl_job_id INT NOT NULL,
+    crawl_run_id INT DEFAULT 0,
+    url TEXT NOT NULL,
+    parent_url TEXT,
+    depth INT DEFAULT 0,
+    source VARCHAR(20) DEFAULT '',
+    status VARCHAR(20) DEFAULT '',
+    error_message TEXT,
+    error_code VARCHAR(30) DEFAULT '',
+    html_version VARCHAR(50) DEFAULT '',
+    page_title TEXT,
+    h1_count INT DEFAULT 0,
+    h2_count INT DEFAULT 0,
+    h3_count INT DEFAULT 0,
+    h4_count INT DEFAULT 0,
+    h5_count INT DEFAULT 0,
+    h6_count INT DEFAULT 0,
+    internal_links INT DEFAULT 0,
+    external_links INT DEFAULT 0,
+    broken_links INT DEFAULT 0,
+    broken_assets INT DEFAULT 0,
+    has_login_form BOOLEAN DEFAULT FALSE,
+    meta_title TEXT,
+    meta_description TEXT,
+    canonical TEXT,
+    inbound_internal_links INT DEFAULT 0,
+    is_orphan BOOLEAN DEFAULT FALSE,
+    status_code INT DEFAULT 0,
+    content_type VARCHAR(255) DEFAULT '',
+    resource_type VARCHAR(20) DEFAULT '',
+    content_length BIGINT DEFAULT 0,
+    content_hash VARCHAR(64) DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (crawl_job_id) REFERENCES crawl_jobs(id) ON DELETE CASCADE,
+    INDEX idx_crawl_pages_job_id (crawl_job_id),
+    INDEX idx_crawl_pages_run_id (crawl_run_id)
+);
+
+-- Crawl schedules table
+CREATE TABLE IF NOT EXISTS crawl_schedules (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    crawl_job_id INT NOT NULL,
+    cron_expr VARCHAR(100) DEFAULT '',
+    interval_seconds INT DEFAULT 0,
+    paused BOOLEAN DEFAULT FALSE,
+    next_run_at TIMESTAMP NULL,
+    last_run_at TIMESTAMP NULL,
+    last_run_status VARCHAR(20) DEFAULT '',
+    last_run_message TEXT,
+    run_count INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (crawl_job_id) REFERENCES crawl_jobs(id) ON DELETE CASCADE,
+    INDEX idx_crawl_schedules_user_id (user_id),
+    INDEX idx_crawl_schedules_job_id (crawl_job_id),
+    INDEX idx_crawl_schedules_next_run_at (next_run_at)
+);
+
+-- Alert subscriptions table
+CREATE TABLE IF NOT EXISTS alert_subscriptions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    crawl_job_id INT DEFAULT 0,
+    type VARCHAR(30) NOT NULL,
+    threshold INT DEFAULT 0,
+    active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_alert_subscriptions_user_id (user_id),
+    INDEX idx_alert_subscriptions_job_id (crawl_job_id)
+);
+
+-- Alerts table
+CREATE TABLE IF NOT EXISTS alerts (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    crawl_job_id INT NOT NULL,
+    crawl_run_id INT DEFAULT 0,
+    subscription_id INT DEFAULT 0,
+    type VARCHAR(30) DEFAULT '',
+    url TEXT,
+    message TEXT,
+    acknowledged_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_alerts_user_id (user_id),
+    INDEX idx_alerts_job_id (crawl_job_id),
+    INDEX idx_alerts_run_id (crawl_run_id)
+);
+
+-- Webhooks table
+CREATE TABLE IF NOT EXISTS webhooks (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    url TEXT NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    events VARCHAR(255) DEFAULT '',
+    active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_webhooks_user_id (user_id)
+);
+
+-- Webhook deliveries table
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    webhook_id INT NOT NULL,
+    crawl_job_id INT,
+    event VARCHAR(50) DEFAULT '',
+    payload MEDIUMTEXT,
+    status VARCHAR(20) DEFAULT 'pending',
+    attempts INT DEFAULT 0,
+    next_attempt_at TIMESTAMP NULL,
+    response_code INT DEFAULT 0,
+    response_body TEXT,
+    last_error TEXT,
+    delivered_at TIMESTAMP NULL,
+    redelivery_of INT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE,
+    INDEX idx_webhook_deliveries_webhook_id (webhook_id),
+    INDEX idx_webhook_deliveries_status (status),
+    INDEX idx_webhook_deliveries_next_attempt_at (next_attempt_at)
+);
+
+-- Crawl profiles table
+CREATE TABLE IF NOT EXISTS crawl_profiles (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    description TEXT,
+    settings_user_agent VARCHAR(255) DEFAULT '',
+    settings_page_timeout_seconds INT NULL,
+    settings_link_timeout_seconds INT NULL,
+    settings_link_concurrency INT NULL,
+    settings_host_concurrency INT NULL,
+    settings_host_interval_ms INT NULL,
+    settings_redirect_policy VARCHAR(20) DEFAULT '',
+    settings_max_redirects INT NULL,
+    settings_headers TEXT,
+    settings_cookies TEXT,
+    settings_scope TEXT,
+    settings_normalization TEXT,
+    settings_url_rules TEXT,
+    settings_skip_link_checks BOOLEAN NULL,
+    settings_skip_external_checks BOOLEAN NULL,
+    settings_check_assets BOOLEAN NULL,
+    settings_bypass_link_cache BOOLEAN NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_crawl_profiles_user_id (user_id)
+);
+
 -- Create indexes for performance
 CREATE INDEX idx_users_api_key ON users(api_key);
 CREATE INDEX idx_crawl_jobs_user_status ON crawl_jobs(user_id, status);
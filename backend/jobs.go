@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Reasons a running job's context is cancelled
+var (
+	errJobStopped   = errors.New("crawl stopped")
+	errShuttingDown = errors.New("server shutting down")
+)
+
+// JobManager owns the cancellation context of every job running in this
+// process. It is safe for concurrent use by HTTP handlers and workers.
+type JobManager struct {
+	mutex     sync.Mutex
+	cancels   map[uint]context.CancelCauseFunc
+	running   sync.WaitGroup
+	root      context.Context
+	cancelAll context.CancelCauseFunc
+}
+
+// NewJobManager creates an empty job manager
+func NewJobManager() *JobManager {
+	root, cancelAll := context.WithCancelCause(context.Background())
+	return &JobManager{
+		cancels:   make(map[uint]context.CancelCauseFunc),
+		root:      root,
+		cancelAll: cancelAll,
+	}
+}
+
+// Begin registers a running job and returns its context, along with a
+// function the caller must invoke once the job has finished
+func (m *JobManager) Begin(jobID uint) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(m.root)
+
+	m.mutex.Lock()
+	m.cancels[jobID] = cancel
+	m.running.Add(1)
+	m.mutex.Unlock()
+
+	done := func() {
+		m.mutex.Lock()
+		delete(m.cancels, jobID)
+		m.mutex.Unlock()
+		cancel(nil)
+		m.running.Done()
+	}
+	return ctx, done
+}
+
+// Cancel stops a running job, reporting whether it was running in this process
+func (m *JobManager) Cancel(jobID uint) bool {
+	m.mutex.Lock()
+	cancel, exists := m.cancels[jobID]
+	m.mutex.Unlock()
+	if exists {
+		cancel(errJobStopped)
+	}
+	return exists
+}
+
+// Running reports whether a job is running in this process
+func (m *JobManager) Running(jobID uint) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, exists := m.cancels[jobID]
+	return exists
+}
+
+// Shutdown cancels every running job and waits for them to wind down, or for
+// ctx to expire
+func (m *JobManager) Shutdown(ctx context.Context) error {
+	m.cancelAll(errShuttingDown)
+
+	finished := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed once Shutdown has been called
+func (m *JobManager) Done() <-chan struct{} {
+	return m.root.Done()
+}
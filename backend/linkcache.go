@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LinkStatusCache holds the latest status of a checked link, shared by every
+// job until it expires
+type LinkStatusCache struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	URLHash    string    `gorm:"type:char(64);not null;uniqueIndex" json:"url_hash"`
+	URL        string    `gorm:"type:text;not null" json:"url"`
+	StatusCode int       `json:"status_code"`
+	ErrorCode  string    `gorm:"type:varchar(30)" json:"error_code,omitempty"`
+	Error      string    `gorm:"type:text" json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+	ExpiresAt  time.Time `gorm:"index" json:"expires_at"`
+	gorm.Model
+}
+
+// checkLinkCached returns the status of a link, from the shared cache when a
+// fresh entry exists and by checking it otherwise. Checked results are
+// stored in the cache even when the crawl bypasses it.
+func (cs *CrawlerService) checkLinkCached(state *crawlState, linkURL, pageURL string) BrokenLinkInfo {
+	key := linkCacheKey(linkURL)
+
+	// Private sessions may see links differently from everyone else
+	if state.privateSession {
+		return cs.checkLinkUncached(state, linkURL, pageURL)
+	}
+
+	if !state.freshLinks {
+		var entry LinkStatusCache
+		err := cs.db.Where("url_hash = ? AND expires_at > ?", key, time.Now()).First(&entry).Error
+		if err == nil {
+			return BrokenLinkInfo{URL: linkURL, StatusCode: entry.StatusCode, Error: entry.Error, ErrorCode: entry.ErrorCode, Cached: true}
+		}
+	}
+
+	info := cs.checkLinkUncached(state, linkURL, pageURL)
+
+	// A check interrupted by a stop says nothing about the link, and one that
+	// still failed transiently after its retries may succeed soon
+	if state.cancelled() || isTransientLinkError(info.ErrorCode, info.StatusCode) {
+		return info
+	}
+
+	now := time.Now()
+	entry := LinkStatusCache{
+		URLHash:    key,
+		URL:        linkURL,
+		StatusCode: info.StatusCode,
+		ErrorCode:  info.ErrorCode,
+		Error:      info.Error,
+		CheckedAt:  now,
+		ExpiresAt:  now.Add(cs.linkCacheTTL),
+	}
+	err := cs.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "url_hash"}},
+		DoUpdates: clause.AssignmentColumns([]string{"url", "status_code", "error_code", "error", "checked_at", "expires_at", "updated_at"}),
+	}).Create(&entry).Error
+	if err != nil {
+		log.Printf("Failed to cache link status for %s: %v", linkURL, err)
+	}
+	return info
+}
+
+// checkLinkUncached checks a link's status, recording any redirects
+func (cs *CrawlerService) checkLinkUncached(state *crawlState, linkURL, pageURL string) BrokenLinkInfo {
+	result := cs.links.Check(state, linkURL)
+	state.recordRedirect(result.trace, RedirectKindLink, pageURL, result.Err)
+	info := BrokenLinkInfo{URL: linkURL, StatusCode: result.StatusCode, ErrorCode: result.ErrorCode}
+	if result.Err != nil {
+		info.Error = result.Err.Error()
+	}
+	return info
+}
+
+// linkCacheKey hashes the form of a URL used to share link statuses: the
+// default normalization, whatever the checking job configures
+func linkCacheKey(rawURL string) string {
+	sum := sha256.Sum256([]byte(normalizeURL(rawURL, URLNormalization{})))
+	return hex.EncodeToString(sum[:])
+}
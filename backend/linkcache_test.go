@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLinkCacheRedirectSettings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			// Send the link to another host name of the same server
+			http.Redirect(w, r, strings.Replace("http://"+r.Host, "127.0.0.1", "localhost", 1)+"/new", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	fetch := func(state *crawlState, client *http.Client, method, rawURL string, header http.Header) (*http.Response, *redirectTrace, error) {
+		return fetchWithRedirects(state.ctx, client, method, rawURL, header, state.settings.redirectPolicy())
+	}
+	checker := NewLinkChecker(fetch, 0)
+	check := func(settings CrawlSettings) (LinkCheckResult, bool) {
+		state := &crawlState{
+			ctx:        context.Background(),
+			settings:   settings,
+			linkClient: &http.Client{CheckRedirect: noRedirects},
+		}
+		return checker.Check(state, server.URL+"/old"), settings.private("Crawler/1.0")
+	}
+
+	defaults := CrawlSettings{UserAgent: "Crawler/1.0", RedirectPolicy: RedirectFollow, MaxRedirects: ptr(maxRedirects)}
+	sameHost := defaults
+	sameHost.RedirectPolicy = RedirectSameHost
+
+	result, private := check(defaults)
+	if result.StatusCode != http.StatusOK || private {
+		t.Errorf("default job: status %d, private %v, want 200 from the shared cache", result.StatusCode, private)
+	}
+	// The same link answers differently to the same_host job, so its result
+	// must not reach the cache other jobs read
+	result, private = check(sameHost)
+	if result.StatusCode != http.StatusFound || !private {
+		t.Errorf("same_host job: status %d, private %v, want 302 kept out of the shared cache", result.StatusCode, private)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	// Default time allowed for each link check request
+	linkCheckTimeout = 10 * time.Second
+	// First wait before retrying a transient failure; doubled on every retry
+	linkRetryBase = 500 * time.Millisecond
+	linkRetryMax  = 5 * time.Second
+)
+
+// Error codes classifying why a link check failed
+const (
+	LinkErrorDNS               = "dns_error"
+	LinkErrorTimeout           = "timeout"
+	LinkErrorTLS               = "tls_error"
+	LinkErrorConnectionRefused = "connection_refused"
+	LinkErrorConnectionReset   = "connection_reset"
+	LinkErrorRedirectLoop      = "redirect_loop"
+	LinkErrorTooManyRedirects  = "too_many_redirects"
+	LinkErrorNetwork           = "network_error"
+	LinkErrorSSRFBlocked       = "ssrf_blocked"
+	LinkErrorHTTP4xx           = "http_4xx"
+	LinkErrorHTTP5xx           = "http_5xx"
+)
+
+// Statuses servers commonly return for HEAD requests they do not support
+var headRejectedStatuses = map[int]bool{
+	http.StatusForbidden:        true,
+	http.StatusMethodNotAllowed: true,
+	http.StatusNotImplemented:   true,
+}
+
+// fetchFunc sends a request within the crawl's per-host limits, following
+// redirects
+type fetchFunc func(state *crawlState, client *http.Client, method, rawURL string, header http.Header) (*http.Response, *redirectTrace, error)
+
+// LinkChecker checks link statuses with each crawl's link client. It tries
+// HEAD first, falls back to a ranged GET when a server answers that it does
+// not support HEAD, and retries transient failures with exponential backoff
+// and jitter.
+type LinkChecker struct {
+	fetch   fetchFunc
+	retries int
+}
+
+// LinkCheckResult is the outcome of checking a link
+type LinkCheckResult struct {
+	StatusCode int
+	ErrorCode  string
+	Err        error
+	Attempts   int
+	trace      *redirectTrace
+}
+
+// NewLinkChecker creates a link checker sending requests through fetch
+func NewLinkChecker(fetch fetchFunc, retries int) *LinkChecker {
+	return &LinkChecker{
+		fetch:   fetch,
+		retries: retries,
+	}
+}
+
+// Check returns the status of a link, retrying transient failures
+func (lc *LinkChecker) Check(state *crawlState, linkURL string) LinkCheckResult {
+	var result LinkCheckResult
+	for attempt := 0; ; attempt++ {
+		result = lc.checkOnce(state, linkURL)
+		result.Attempts = attempt + 1
+		if attempt >= lc.retries || !isTransientLinkError(result.ErrorCode, result.StatusCode) || state.cancelled() {
+			return result
+		}
+		if sleepContext(state.ctx, retryBackoff(attempt)) != nil {
+			return result
+		}
+	}
+}
+
+// checkOnce checks a link once, with HEAD and then a ranged GET if needed
+func (lc *LinkChecker) checkOnce(state *crawlState, linkURL string) LinkCheckResult {
+	resp, trace, err := lc.fetch(state, state.linkClient, http.MethodHead, linkURL, nil)
+	if err != nil {
+		// A GET would fail the same way, after as long a wait
+		return linkResult(lastStatus(trace), trace, err)
+	}
+	resp.Body.Close()
+	if !headRejectedStatuses[resp.StatusCode] {
+		return linkResult(resp.StatusCode, trace, nil)
+	}
+
+	// The server does not support HEAD; ask for a single byte
+	resp, trace, err = lc.fetch(state, state.linkClient, http.MethodGet, linkURL, http.Header{"Range": {"bytes=0-0"}})
+	if err != nil {
+		return linkResult(lastStatus(trace), trace, err)
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// Empty resources cannot satisfy the range; fetch them whole
+		resp.Body.Close()
+		resp, trace, err = lc.fetch(state, state.linkClient, http.MethodGet, linkURL, nil)
+		if err != nil {
+			return linkResult(lastStatus(trace), trace, err)
+		}
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	return linkResult(resp.StatusCode, trace, nil)
+}
+
+func linkResult(statusCode int, trace *redirectTrace, err error) LinkCheckResult {
+	return LinkCheckResult{
+		StatusCode: statusCode,
+		ErrorCode:  classifyLinkError(statusCode, err),
+		Err:        err,
+		trace:      trace,
+	}
+}
+
+// classifyLinkError returns the error code for a failed check, or "" when
+// the link responded with a status below 400
+func classifyLinkError(statusCode int, err error) string {
+	if err == nil {
+		switch {
+		case statusCode >= 500:
+			return LinkErrorHTTP5xx
+		case statusCode >= 400:
+			return LinkErrorHTTP4xx
+		}
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var blockedErr *blockedAddressError
+	switch {
+	case errors.As(err, &blockedErr):
+		return LinkErrorSSRFBlocked
+	case errors.Is(err, errRedirectLoop):
+		return LinkErrorRedirectLoop
+	case errors.Is(err, errTooManyRedirects):
+		return LinkErrorTooManyRedirects
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsTimeout {
+			return LinkErrorTimeout
+		}
+		return LinkErrorDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return LinkErrorTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return LinkErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return LinkErrorConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return LinkErrorConnectionReset
+	}
+	return LinkErrorNetwork
+}
+
+// isTransientLinkError reports whether a failed check may succeed if retried
+func isTransientLinkError(errorCode string, statusCode int) bool {
+	switch errorCode {
+	case LinkErrorTimeout, LinkErrorConnectionReset, LinkErrorConnectionRefused:
+		return true
+	case LinkErrorHTTP5xx:
+		return statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable || statusCode == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+// retryBackoff returns the wait before retry number attempt+1. It doubles
+// from linkRetryBase up to linkRetryMax, and a random part of up to half of it
+// spreads out retries to the same host.
+func retryBackoff(attempt int) time.Duration {
+	wait := linkRetryBase << attempt
+	if wait > linkRetryMax || wait <= 0 {
+		wait = linkRetryMax
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyLinkError(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Head", URL: "https://example.com/", Err: err}
+	}
+	opErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		want   string
+	}{
+		{"ok", 200, nil, ""},
+		{"redirect", 301, nil, ""},
+		{"not found", 404, nil, LinkErrorHTTP4xx},
+		{"server error", 503, nil, LinkErrorHTTP5xx},
+		{"ssrf", 0, wrap(&blockedAddressError{addr: netip.MustParseAddr("10.0.0.1")}), LinkErrorSSRFBlocked},
+		{"redirect loop", 0, wrap(errRedirectLoop), LinkErrorRedirectLoop},
+		{"too many redirects", 0, wrap(fmt.Errorf("%w after 10", errTooManyRedirects)), LinkErrorTooManyRedirects},
+		{"dns", 0, wrap(&net.DNSError{Err: "no such host", Name: "missing.example"}), LinkErrorDNS},
+		{"dns timeout", 0, wrap(&net.DNSError{Err: "timeout", IsTimeout: true}), LinkErrorTimeout},
+		{"tls", 0, wrap(x509.UnknownAuthorityError{}), LinkErrorTLS},
+		{"hostname", 0, wrap(x509.HostnameError{Host: "example.com"}), LinkErrorTLS},
+		{"deadline", 0, wrap(context.DeadlineExceeded), LinkErrorTimeout},
+		{"dial timeout", 0, wrap(&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}), LinkErrorTimeout},
+		{"refused", 0, wrap(opErr(syscall.ECONNREFUSED)), LinkErrorConnectionRefused},
+		{"reset", 0, wrap(opErr(syscall.ECONNRESET)), LinkErrorConnectionReset},
+		{"eof", 0, wrap(io.EOF), LinkErrorConnectionReset},
+		{"other", 0, wrap(errors.New("something else")), LinkErrorNetwork},
+	}
+	for _, tt := range tests {
+		if got := classifyLinkError(tt.status, tt.err); got != tt.want {
+			t.Errorf("%s: classifyLinkError = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsTransientLinkError(t *testing.T) {
+	tests := []struct {
+		code   string
+		status int
+		want   bool
+	}{
+		{LinkErrorTimeout, 0, true},
+		{LinkErrorConnectionReset, 0, true},
+		{LinkErrorConnectionRefused, 0, true},
+		{LinkErrorHTTP5xx, 502, true},
+		{LinkErrorHTTP5xx, 503, true},
+		{LinkErrorHTTP5xx, 504, true},
+		{LinkErrorHTTP5xx, 500, false},
+		{LinkErrorHTTP4xx, 404, false},
+		{LinkErrorDNS, 0, false},
+		{LinkErrorTLS, 0, false},
+		{LinkErrorSSRFBlocked, 0, false},
+	}
+	for _, tt := range tests {
+		if got := isTransientLinkError(tt.code, tt.status); got != tt.want {
+			t.Errorf("isTransientLinkError(%q, %d) = %v, want %v", tt.code, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, linkRetryBase},
+		{1, 2 * linkRetryBase},
+		{2, 4 * linkRetryBase},
+		{10, linkRetryMax},
+		// Shifting this far overflows, which must still give the maximum
+		{70, linkRetryMax},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			got := retryBackoff(tt.attempt)
+			if got < tt.max/2 || got > tt.max {
+				t.Fatalf("retryBackoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestLinkCheckerHeadFallback(t *testing.T) {
+	blocked := fmt.Errorf("refused to fetch URL: %w", &blockedAddressError{addr: netip.MustParseAddr("10.0.0.1")})
+	tests := []struct {
+		name       string
+		headStatus int
+		headErr    error
+		wantCode   string
+		wantMethod []string
+	}{
+		{"head ok", http.StatusOK, nil, "", []string{http.MethodHead}},
+		{"head not found", http.StatusNotFound, nil, LinkErrorHTTP4xx, []string{http.MethodHead}},
+		{"head not allowed", http.StatusMethodNotAllowed, nil, "", []string{http.MethodHead, http.MethodGet}},
+		{"head not implemented", http.StatusNotImplemented, nil, "", []string{http.MethodHead, http.MethodGet}},
+		{"ssrf blocked", 0, blocked, LinkErrorSSRFBlocked, []string{http.MethodHead}},
+		{"dns error", 0, &net.DNSError{Err: "no such host", Name: "missing.invalid"}, LinkErrorDNS, []string{http.MethodHead}},
+		{"timeout", 0, context.DeadlineExceeded, LinkErrorTimeout, []string{http.MethodHead}},
+	}
+	for _, tt := range tests {
+		var methods []string
+		fetch := func(state *crawlState, client *http.Client, method, rawURL string, header http.Header) (*http.Response, *redirectTrace, error) {
+			methods = append(methods, method)
+			if method == http.MethodHead {
+				if tt.headErr != nil {
+					return nil, &redirectTrace{}, tt.headErr
+				}
+				return &http.Response{StatusCode: tt.headStatus, Body: http.NoBody}, &redirectTrace{}, nil
+			}
+			return &http.Response{StatusCode: http.StatusPartialContent, Body: http.NoBody}, &redirectTrace{}, nil
+		}
+		state := &crawlState{ctx: context.Background()}
+
+		result := NewLinkChecker(fetch, 0).Check(state, "https://example.com/")
+		if result.ErrorCode != tt.wantCode {
+			t.Errorf("%s: error code = %q, want %q", tt.name, result.ErrorCode, tt.wantCode)
+		}
+		if fmt.Sprint(methods) != fmt.Sprint(tt.wantMethod) {
+			t.Errorf("%s: sent %v, want %v", tt.name, methods, tt.wantMethod)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
@@ -8,8 +9,10 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -23,61 +26,160 @@ import (
 
 // Models
 type User struct {
-	ID          uint   `gorm:"primaryKey" json:"id"`
-	Username    string `gorm:"unique;not null" json:"username"`
-	Password    string `gorm:"not null" json:"-"`
-	APIKey      string `gorm:"unique;not null" json:"api_key"`
-	FirstName   string `gorm:"type:varchar(100);default:''" json:"first_name"`
-	LastName    string `gorm:"type:varchar(100);default:''" json:"last_name"`
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	Username     string `gorm:"unique;not null" json:"username"`
+	Password     string `gorm:"not null" json:"-"`
+	APIKey       string `gorm:"unique;not null" json:"api_key"`
+	FirstName    string `gorm:"type:varchar(100);default:''" json:"first_name"`
+	LastName     string `gorm:"type:varchar(100);default:''" json:"last_name"`
 	Subscription string `gorm:"type:varchar(50);default:'Free'" json:"subscription"`
 	gorm.Model
 }
 
 type CrawlJob struct {
-	ID              uint       `gorm:"primaryKey" json:"id"`
-	UserID          uint       `gorm:"not null" json:"user_id"`
-	URL             string     `gorm:"not null" json:"url"`
-	Status          string     `gorm:"default:'queued'" json:"status"` // queued, running, completed, error, stopped
-	HTMLVersion     string     `json:"html_version"`
-	PageTitle       string     `json:"page_title"`
-	H1Count         int        `json:"h1_count"`
-	H2Count         int        `json:"h2_count"`
-	H3Count         int        `json:"h3_count"`
-	H4Count         int        `json:"h4_count"`
-	H5Count         int        `json:"h5_count"`
-	H6Count         int        `json:"h6_count"`
-	InternalLinks   int        `json:"internal_links"`
-	ExternalLinks   int        `json:"external_links"`
-	BrokenLinks     int        `json:"broken_links"`
-	HasLoginForm    bool       `json:"has_login_form"`
-	ErrorMessage    string     `json:"error_message,omitempty"`
-	StartedAt       *time.Time `json:"started_at"`
-	CompletedAt     *time.Time `json:"completed_at"`
-	MetaTitle       string     `gorm:"type:text" json:"meta_title"`
-	MetaDescription string     `gorm:"type:text" json:"meta_description"`
-	Canonical       string     `gorm:"type:text" json:"canonical"`
-	HasJSONLD       bool       `json:"has_jsonld"`
-	HasMicrodata    bool       `json:"has_microdata"`
-	HasRDFa         bool       `json:"has_rdfa"`
-	JSONLDSnippet   string     `json:"jsonld_snippet"`
-	MicrodataSnippet string    `json:"microdata_snippet"`
-	RDFaSnippet     string     `json:"rdfa_snippet"`
+	ID               uint              `gorm:"primaryKey" json:"id"`
+	UserID           uint              `gorm:"not null" json:"user_id"`
+	URL              string            `gorm:"not null" json:"url"`
+	Status           string            `gorm:"default:'queued'" json:"status"` // queued, running, completed, error, stopped
+	HTMLVersion      string            `json:"html_version"`
+	PageTitle        string            `json:"page_title"`
+	H1Count          int               `json:"h1_count"`
+	H2Count          int               `json:"h2_count"`
+	H3Count          int               `json:"h3_count"`
+	H4Count          int               `json:"h4_count"`
+	H5Count          int               `json:"h5_count"`
+	H6Count          int               `json:"h6_count"`
+	InternalLinks    int               `json:"internal_links"`
+	ExternalLinks    int               `json:"external_links"`
+	BrokenLinks      int               `json:"broken_links"`
+	BrokenAssets     int               `json:"broken_assets"`
+	HasLoginForm     bool              `json:"has_login_form"`
+	ErrorMessage     string            `json:"error_message,omitempty"`
+	ErrorCode        string            `gorm:"type:varchar(30)" json:"error_code,omitempty"` // ssrf_blocked when the page was refused
+	QueuedAt         *time.Time        `gorm:"index" json:"queued_at"`
+	WorkerID         string            `gorm:"type:varchar(100)" json:"worker_id"`
+	HeartbeatAt      *time.Time        `json:"heartbeat_at"`
+	Attempts         int               `json:"attempts"`
+	StartedAt        *time.Time        `json:"started_at"`
+	CompletedAt      *time.Time        `json:"completed_at"`
+	MetaTitle        string            `gorm:"type:text" json:"meta_title"`
+	MetaDescription  string            `gorm:"type:text" json:"meta_description"`
+	Canonical        string            `gorm:"type:text" json:"canonical"`
+	HasJSONLD        bool              `json:"has_jsonld"`
+	HasMicrodata     bool              `json:"has_microdata"`
+	HasRDFa          bool              `json:"has_rdfa"`
+	JSONLDSnippet    string            `json:"jsonld_snippet"`
+	MicrodataSnippet string            `json:"microdata_snippet"`
+	RDFaSnippet      string            `json:"rdfa_snippet"`
+	Mode             string            `gorm:"type:varchar(20);default:'page'" json:"mode"` // page, site
+	MaxDepth         int               `gorm:"default:0" json:"max_depth"`
+	MaxPages         int               `gorm:"default:1" json:"max_pages"`
+	ProfileID        *uint             `gorm:"index" json:"profile_id"`
+	CrawlSettings    `gorm:"embedded"` // overrides of the profile's settings
+	AuthType         string            `gorm:"type:varchar(20)" json:"auth_type"` // basic, bearer, cookies, form
+	Credentials      string            `gorm:"type:text" json:"-"`                // encrypted CrawlAuth
+	FreshLinkChecks  bool              `json:"fresh_link_checks"`                 // bypass the link cache on the next run only
+	PagesCrawled     int               `json:"pages_crawled"`
+	SkippedLinks     int               `json:"skipped_links"`
+	CurrentRunID     *uint             `json:"current_run_id"`
+	TriggeredBy      string            `gorm:"type:varchar(20)" json:"triggered_by"` // manual, schedule
+	StatusCode       int               `json:"status_code"`
+	ContentType      string            `gorm:"type:varchar(255)" json:"content_type"`
+	ResourceType     string            `gorm:"type:varchar(20)" json:"resource_type"` // html, pdf, image, json, xml, ...
+	ContentLength    int64             `json:"content_length"`
+	ResponseHeaders  map[string]string `gorm:"serializer:json;type:text" json:"response_headers"`
+	ContentHash      string            `gorm:"type:varchar(64)" json:"content_hash"`
+	ContentChanged   bool              `json:"content_changed"`
+	MonitorSelectors []string          `gorm:"serializer:json;type:text" json:"monitor_selectors"`
+	RegionHashes     map[string]string `gorm:"serializer:json;type:text" json:"region_hashes"`
+	ChangedRegions   []string          `gorm:"serializer:json;type:text" json:"changed_regions"`
+	gorm.Model
+}
+
+// CrawlPage is the per-page result of a site crawl
+type CrawlPage struct {
+	ID                   uint   `gorm:"primaryKey" json:"id"`
+	CrawlJobID           uint   `gorm:"not null;index" json:"crawl_job_id"`
+	CrawlRunID           uint   `gorm:"index" json:"crawl_run_id"`
+	URL                  string `gorm:"type:text;not null" json:"url"`
+	ParentURL            string `gorm:"type:text" json:"parent_url"`
+	Depth                int    `json:"depth"`
+	Source               string `gorm:"type:varchar(20)" json:"source"` // entry, link, sitemap
+	Status               string `json:"status"`                         // completed, error
+	ErrorMessage         string `gorm:"type:text" json:"error_message,omitempty"`
+	ErrorCode            string `gorm:"type:varchar(30)" json:"error_code,omitempty"`
+	HTMLVersion          string `json:"html_version"`
+	PageTitle            string `gorm:"type:text" json:"page_title"`
+	H1Count              int    `json:"h1_count"`
+	H2Count              int    `json:"h2_count"`
+	H3Count              int    `json:"h3_count"`
+	H4Count              int    `json:"h4_count"`
+	H5Count              int    `json:"h5_count"`
+	H6Count              int    `json:"h6_count"`
+	InternalLinks        int    `json:"internal_links"`
+	ExternalLinks        int    `json:"external_links"`
+	BrokenLinks          int    `json:"broken_links"`
+	BrokenAssets         int    `json:"broken_assets"`
+	HasLoginForm         bool   `json:"has_login_form"`
+	MetaTitle            string `gorm:"type:text" json:"meta_title"`
+	MetaDescription      string `gorm:"type:text" json:"meta_description"`
+	Canonical            string `gorm:"type:text" json:"canonical"`
+	InboundInternalLinks int    `json:"inbound_internal_links"`
+	IsOrphan             bool   `json:"is_orphan"`
+	StatusCode           int    `json:"status_code"`
+	ContentType          string `gorm:"type:varchar(255)" json:"content_type"`
+	ResourceType         string `gorm:"type:varchar(20)" json:"resource_type"`
+	ContentLength        int64  `json:"content_length"`
+	ContentHash          string `gorm:"type:varchar(64)" json:"content_hash"`
 	gorm.Model
 }
 
 type BrokenLink struct {
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	CrawlJobID   uint   `gorm:"not null" json:"crawl_job_id"`
+	CrawlRunID   uint   `gorm:"index" json:"crawl_run_id"`
+	URL          string `gorm:"not null" json:"url"`
+	StatusCode   int    `json:"status_code"`
+	ErrorCode    string `gorm:"type:varchar(30)" json:"error_code"`
+	ErrorMessage string `gorm:"type:text" json:"error_message"`
+	Cached       bool   `json:"cached"`
+	PageURL      string `gorm:"type:text" json:"page_url"`
+	AnchorText   string `gorm:"type:text" json:"anchor_text"`
+	Element      string `gorm:"type:varchar(20)" json:"element"`   // a, img, script, link, ...
+	Attribute    string `gorm:"type:varchar(20)" json:"attribute"` // href, src, srcset, poster
+	Rel          string `gorm:"type:varchar(255)" json:"rel"`
+	Occurrences  int    `gorm:"default:1" json:"occurrences"`       // times the link appears on the page
+	AssetType    string `gorm:"type:varchar(20)" json:"asset_type"` // image, script, stylesheet, ...; empty for anchors
+	gorm.Model
+}
+
+// CrawlSeed is an extra starting URL for a site crawl, such as a sitemap entry
+type CrawlSeed struct {
 	ID         uint   `gorm:"primaryKey" json:"id"`
-	CrawlJobID uint   `gorm:"not null" json:"crawl_job_id"`
-	URL        string `gorm:"not null" json:"url"`
-	StatusCode int    `json:"status_code"`
+	CrawlJobID uint   `gorm:"not null;index" json:"crawl_job_id"`
+	URL        string `gorm:"type:text;not null" json:"url"`
+	gorm.Model
+}
+
+// SkippedLink is a discovered link that was deliberately not fetched
+type SkippedLink struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	CrawlJobID uint   `gorm:"not null;index" json:"crawl_job_id"`
+	CrawlRunID uint   `gorm:"index" json:"crawl_run_id"`
+	URL        string `gorm:"type:text;not null" json:"url"`
+	PageURL    string `gorm:"type:text" json:"page_url"`
+	Reason     string `gorm:"type:varchar(50)" json:"reason"` // robots, rule
+	Detail     string `gorm:"type:text" json:"detail"`
 	gorm.Model
 }
 
 type InternalLink struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	FromJobID uint      `gorm:"not null" json:"from_job_id"`
-	ToURL     string    `gorm:"not null" json:"to_url"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	FromJobID  uint      `gorm:"not null" json:"from_job_id"`
+	CrawlRunID uint      `gorm:"index" json:"crawl_run_id"`
+	FromURL    string    `gorm:"type:text" json:"from_url"`
+	ToURL      string    `gorm:"not null" json:"to_url"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 // Database connection
@@ -86,12 +188,27 @@ var db *gorm.DB
 // JWT secret key
 var jwtSecret []byte
 
-// Job cancellation channels
-var jobCancellations = make(map[uint]chan bool)
+// Running job cancellation
+var jobManager = NewJobManager()
+
+// Job event fan-out for streaming clients
+var eventBroker = NewEventBroker()
 
 // Crawler service
 var crawlerService *CrawlerService
 
+// Crawl job queue
+var jobQueue *JobQueue
+
+// Outbound webhook delivery
+var webhookDispatcher *WebhookDispatcher
+
+// Addresses requests for user supplied URLs may connect to
+var destinations *DestinationPolicy
+
+// Recurring crawl scheduler
+var scheduler *Scheduler
+
 // Initialize database
 func initDB() {
 	err := godotenv.Load()
@@ -103,6 +220,9 @@ func initDB() {
 	secret := getEnv("JWT_SECRET", "your-secret-key-change-in-production")
 	jwtSecret = []byte(secret)
 
+	// Job credentials are encrypted with a key derived from CREDENTIALS_KEY
+	setCredentialsKey(getEnv("CREDENTIALS_KEY", ""))
+
 	dbUser := getEnv("DB_USER", "root")
 	dbPassword := getEnv("DB_PASSWORD", "")
 	dbHost := getEnv("DB_HOST", "localhost")
@@ -118,13 +238,39 @@ func initDB() {
 	}
 
 	// Auto-migrate the schema
-	err = db.AutoMigrate(&User{}, &CrawlJob{}, &BrokenLink{}, &InternalLink{})
+	err = db.AutoMigrate(&User{}, &CrawlJob{}, &CrawlRun{}, &CrawlPage{}, &CrawlSeed{}, &BrokenLink{}, &SkippedLink{}, &InternalLink{}, &Webhook{}, &WebhookDelivery{}, &CrawlSchedule{}, &AlertSubscription{}, &Alert{}, &RedirectChain{}, &LinkStatusCache{}, &CrawlProfile{})
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Requests for user supplied URLs may not reach private or reserved
+	// addresses unless they are explicitly allowed
+	destinations, err = NewDestinationPolicy(
+		getEnv("SSRF_PROTECTION", "true") == "true",
+		getEnv("SSRF_DENY_CIDRS", ""),
+		getEnv("SSRF_ALLOW_CIDRS", ""),
+	)
+	if err != nil {
+		log.Fatal("Invalid SSRF address ranges:", err)
+	}
+
 	// Initialize crawler service
-	crawlerService = NewCrawlerService(db)
+	crawlerService = NewCrawlerService(db, destinations)
+
+	// Initialize job queue
+	jobQueue = NewJobQueue(db, crawlerService, jobManager, JobQueueConfig{
+		Workers:           getEnvInt("CRAWL_WORKERS", 4),
+		PollInterval:      time.Duration(getEnvInt("CRAWL_QUEUE_POLL_SECONDS", 5)) * time.Second,
+		HeartbeatInterval: time.Duration(getEnvInt("CRAWL_HEARTBEAT_SECONDS", 15)) * time.Second,
+		LeaseTimeout:      time.Duration(getEnvInt("CRAWL_LEASE_SECONDS", 120)) * time.Second,
+		MaxAttempts:       getEnvInt("CRAWL_MAX_ATTEMPTS", 3),
+	})
+
+	// Initialize webhook dispatcher
+	webhookDispatcher = NewWebhookDispatcher(db, destinations)
+
+	// Initialize crawl scheduler
+	scheduler = NewScheduler(db, jobQueue, time.Duration(getEnvInt("SCHEDULER_POLL_SECONDS", 30))*time.Second)
 }
 
 func getEnv(key, defaultValue string) string {
@@ -134,6 +280,19 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInt reads a positive integer setting, exiting if it is malformed
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		log.Fatalf("Invalid %s value: %q", key, value)
+	}
+	return parsed
+}
+
 // Generate API key
 func generateAPIKey() string {
 	bytes := make([]byte, 32)
@@ -215,10 +374,10 @@ func authMiddleware() gin.HandlerFunc {
 // Auth handlers
 func register(c *gin.Context) {
 	var req struct {
-		Username     string `json:"username" binding:"required"`
-		Password     string `json:"password" binding:"required"`
-		FirstName    string `json:"firstName"`
-		LastName     string `json:"lastName"`
+		Username  string `json:"username" binding:"required"`
+		Password  string `json:"password" binding:"required"`
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -257,10 +416,10 @@ func register(c *gin.Context) {
 		"message": "User created successfully",
 		"api_key": user.APIKey,
 		"user": gin.H{
-			"id": user.ID,
-			"email": user.Username,
-			"firstName": user.FirstName,
-			"lastName": user.LastName,
+			"id":           user.ID,
+			"email":        user.Username,
+			"firstName":    user.FirstName,
+			"lastName":     user.LastName,
 			"subscription": user.Subscription,
 		},
 	})
@@ -309,10 +468,10 @@ func login(c *gin.Context) {
 		"token":   tokenString,
 		"api_key": user.APIKey,
 		"user": gin.H{
-			"id": user.ID,
-			"email": user.Username,
-			"firstName": user.FirstName,
-			"lastName": user.LastName,
+			"id":           user.ID,
+			"email":        user.Username,
+			"firstName":    user.FirstName,
+			"lastName":     user.LastName,
 			"subscription": user.Subscription,
 		},
 	})
@@ -321,7 +480,14 @@ func login(c *gin.Context) {
 // Crawl job handlers
 func addURL(c *gin.Context) {
 	var req struct {
-		URL string `json:"url" binding:"required"`
+		URL              string     `json:"url" binding:"required"`
+		Mode             string     `json:"mode"`
+		MaxDepth         int        `json:"max_depth"`
+		MaxPages         int        `json:"max_pages"`
+		MonitorSelectors []string   `json:"monitor_selectors"`
+		ProfileID        *uint      `json:"profile_id"`
+		Auth             *CrawlAuth `json:"auth"`
+		CrawlSettings
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -329,22 +495,94 @@ func addURL(c *gin.Context) {
 		return
 	}
 
-	// Add protocol if missing
-	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
-		req.URL = "https://" + req.URL
+	mode, maxDepth, maxPages, err := resolveCrawlBudget(req.Mode, req.MaxDepth, req.MaxPages)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Validate URL
-	if _, err := url.ParseRequestURI(req.URL); err != nil {
+	targetURL, err := normalizeInputURL(req.URL)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL format"})
 		return
 	}
+	if err := destinations.CheckURL(c.Request.Context(), targetURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
+	// Settings given inline override those of the profile
+	if err := req.CrawlSettings.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Headers and cookies are stored encrypted like credentials
+	if req.CrawlSettings.hasSecrets() && credentialsKey == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Credential storage is not configured"})
+		return
+	}
 	userID := c.GetUint("user_id")
+	var profileSettings CrawlSettings
+	if req.ProfileID != nil {
+		var profile CrawlProfile
+		if err := db.Where("id = ? AND user_id = ?", *req.ProfileID, userID).First(&profile).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Profile not found"})
+			return
+		}
+		profileSettings = profile.Settings
+	}
+
+	// Discovered links are normalized as configured, and so is the job URL
+	// they are compared against
+	targetURL = pageKey(targetURL, profileSettings.Override(req.CrawlSettings).Normalization)
+
+	// Regions monitored for changes are given as CSS selectors
+	if len(req.MonitorSelectors) > maxMonitorSelectors {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d monitor_selectors are allowed", maxMonitorSelectors)})
+		return
+	}
+	for _, selector := range req.MonitorSelectors {
+		if _, err := parseSelector(selector); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	job := CrawlJob{
-		UserID: userID,
-		URL:    req.URL,
-		Status: "queued",
+		UserID:           userID,
+		URL:              targetURL,
+		Status:           "queued",
+		Mode:             mode,
+		MaxDepth:         maxDepth,
+		MaxPages:         maxPages,
+		MonitorSelectors: req.MonitorSelectors,
+		ProfileID:        req.ProfileID,
+		CrawlSettings:    req.CrawlSettings,
+	}
+
+	// Credentials are only stored encrypted
+	if req.Auth != nil {
+		if err := req.Auth.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Auth.Form != nil {
+			if err := destinations.CheckURL(c.Request.Context(), req.Auth.Form.URL); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if credentialsKey == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Credential storage is not configured"})
+			return
+		}
+		credentials, err := encryptCredentials(req.Auth)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credentials"})
+			return
+		}
+		job.AuthType = req.Auth.Type
+		job.Credentials = credentials
 	}
 
 	if err := db.Create(&job).Error; err != nil {
@@ -355,6 +593,46 @@ func addURL(c *gin.Context) {
 	c.JSON(http.StatusCreated, job)
 }
 
+// normalizeInputURL adds a missing protocol to a user-supplied URL and validates it
+func normalizeInputURL(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		rawURL = "https://" + rawURL
+	}
+	parsed, err := url.ParseRequestURI(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("invalid URL format")
+	}
+	return rawURL, nil
+}
+
+// resolveCrawlBudget validates a crawl mode and its depth and page budgets,
+// filling in defaults
+func resolveCrawlBudget(mode string, maxDepth, maxPages int) (string, int, int, error) {
+	if mode == "" {
+		mode = CrawlModePage
+	}
+	if mode != CrawlModePage && mode != CrawlModeSite {
+		return "", 0, 0, fmt.Errorf("mode must be 'page' or 'site'")
+	}
+	if maxDepth < 0 || maxDepth > maxSiteDepth {
+		return "", 0, 0, fmt.Errorf("max_depth must be between 0 and %d", maxSiteDepth)
+	}
+	if maxPages < 0 || maxPages > maxSitePages {
+		return "", 0, 0, fmt.Errorf("max_pages must be between 0 and %d", maxSitePages)
+	}
+	if mode == CrawlModePage {
+		return mode, 0, 1, nil
+	}
+	if maxDepth == 0 {
+		maxDepth = defaultSiteMaxDepth
+	}
+	if maxPages == 0 {
+		maxPages = defaultSiteMaxPages
+	}
+	return mode, maxDepth, maxPages, nil
+}
+
 func startCrawl(c *gin.Context) {
 	jobID := c.Param("id")
 	userID := c.GetUint("user_id")
@@ -370,14 +648,23 @@ func startCrawl(c *gin.Context) {
 		return
 	}
 
-	// Create cancellation channel
-	cancelChan := make(chan bool, 1)
-	jobCancellations[job.ID] = cancelChan
+	if job.QueuedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Job is already waiting for a worker"})
+		return
+	}
 
-	// Start crawling using the crawler service
-	go crawlerService.CrawlURL(&job, cancelChan)
+	// fresh=true checks every link again on this run instead of using the link cache
+	if c.Query("fresh") == "true" {
+		db.Model(&job).Update("fresh_link_checks", true)
+	}
+
+	// Hand the job to the worker pool
+	if err := jobQueue.Enqueue(&job, RunTriggerManual); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue crawl job"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Crawl started"})
+	c.JSON(http.StatusOK, gin.H{"message": "Crawl queued"})
 }
 
 func stopCrawl(c *gin.Context) {
@@ -390,25 +677,56 @@ func stopCrawl(c *gin.Context) {
 		return
 	}
 
+	// A job still waiting for a worker is simply taken off the queue
+	if job.Status == "queued" && job.QueuedAt != nil {
+		if jobQueue.Dequeue(&job) {
+			c.JSON(http.StatusOK, gin.H{"message": "Crawl removed from queue"})
+			return
+		}
+		// A worker claimed it in the meantime
+		job.Status = "running"
+	}
+
 	if job.Status != "running" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Job is not running"})
 		return
 	}
 
 	// Send cancellation signal
-	if cancelChan, exists := jobCancellations[job.ID]; exists {
-		select {
-		case cancelChan <- true:
-		default:
-		}
+	if jobManager.Cancel(job.ID) {
+		c.JSON(http.StatusOK, gin.H{"message": "Crawl stop requested"})
+		return
+	}
+
+	// The job runs on another instance, or nowhere if its worker went away.
+	// It is marked stopped here; a live worker sees that at its next
+	// heartbeat and cancels the crawl.
+	stale := jobQueue.IsStale(&job)
+	message := errJobStopped.Error()
+	if stale {
+		message = "Stopped after its worker stopped sending heartbeats"
+	}
+	completed := time.Now()
+	result := db.Model(&job).Where("status = ?", "running").Updates(map[string]interface{}{
+		"status":        "stopped",
+		"completed_at":  &completed,
+		"error_message": message,
+	})
+	if result.RowsAffected == 1 {
+		closeRun(&job, "stopped", message)
+		notifyJobStatus(&job, EventStopped, message)
 	}
 
+	if stale {
+		c.JSON(http.StatusOK, gin.H{"message": "Crawl stopped"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Crawl stop requested"})
 }
 
 func getCrawlJobs(c *gin.Context) {
 	userID := c.GetUint("user_id")
-	
+
 	// Parse query parameters
 	page := 1
 	limit := 10
@@ -448,7 +766,7 @@ func getCrawlJobs(c *gin.Context) {
 	}
 
 	query := db.Where("user_id = ?", userID)
-	
+
 	// Apply search filter
 	if search != "" {
 		query = query.Where("url LIKE ? OR page_title LIKE ?", "%"+search+"%", "%"+search+"%")
@@ -489,13 +807,38 @@ func getCrawlJobDetails(c *gin.Context) {
 		return
 	}
 
-	// Get broken links
-	var brokenLinks []BrokenLink
-	db.Where("crawl_job_id = ?", job.ID).Find(&brokenLinks)
+	// Results of earlier runs are available through the runs endpoints
+	runID := currentRunID(&job)
+
+	// Get broken links, with broken assets grouped by type
+	var broken []BrokenLink
+	db.Where("crawl_job_id = ? AND crawl_run_id = ?", job.ID, runID).Find(&broken)
+	brokenLinks, brokenAssets := groupBrokenAssets(broken)
+
+	// Get pages visited by a site crawl
+	var pages []CrawlPage
+	db.Where("crawl_job_id = ? AND crawl_run_id = ?", job.ID, runID).Order("depth asc, id asc").Find(&pages)
+
+	// Get links skipped because of robots.txt rules
+	var skippedLinks []SkippedLink
+	db.Where("crawl_job_id = ? AND crawl_run_id = ?", job.ID, runID).Find(&skippedLinks)
+
+	// Get redirect chains followed by the page fetches and link checks
+	var redirectChains []RedirectChain
+	db.Where("crawl_job_id = ? AND crawl_run_id = ?", job.ID, runID).Find(&redirectChains)
+
+	// Get schedules that requeue this job
+	var schedules []CrawlSchedule
+	db.Where("crawl_job_id = ?", job.ID).Find(&schedules)
 
 	c.JSON(http.StatusOK, gin.H{
-		"job":          job,
-		"broken_links": brokenLinks,
+		"job":             job,
+		"broken_links":    brokenLinks,
+		"broken_assets":   brokenAssets,
+		"pages":           pages,
+		"skipped_links":   skippedLinks,
+		"redirect_chains": redirectChains,
+		"schedules":       schedules,
 	})
 }
 
@@ -511,17 +854,38 @@ func deleteCrawlJobs(c *gin.Context) {
 
 	userID := c.GetUint("user_id")
 
-	// Delete broken links first
-	db.Where("crawl_job_id IN (SELECT id FROM crawl_jobs WHERE id IN ? AND user_id = ?)", req.IDs, userID).Delete(&BrokenLink{})
-	
-	// Delete crawl jobs
-	result := db.Where("id IN ? AND user_id = ?", req.IDs, userID).Delete(&CrawlJob{})
+	// Only the user's own jobs are stopped and deleted
+	var ids []uint
+	db.Model(&CrawlJob{}).Where("id IN ? AND user_id = ?", req.IDs, userID).Pluck("id", &ids)
+	if len(ids) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Jobs deleted successfully",
+			"deleted": 0,
+		})
+		return
+	}
 
-	// Clean up cancellation channels
-	for _, id := range req.IDs {
-		delete(jobCancellations, id)
+	// Stop running jobs first, so they cannot store results for a job that
+	// no longer exists
+	for _, id := range ids {
+		jobManager.Cancel(id)
 	}
 
+	// Delete broken links first
+	db.Where("crawl_job_id IN ?", ids).Delete(&BrokenLink{})
+	db.Where("crawl_job_id IN ?", ids).Delete(&CrawlPage{})
+	db.Where("crawl_job_id IN ?", ids).Delete(&CrawlSeed{})
+	db.Where("crawl_job_id IN ?", ids).Delete(&SkippedLink{})
+	db.Where("crawl_job_id IN ?", ids).Delete(&RedirectChain{})
+	db.Where("from_job_id IN ?", ids).Delete(&InternalLink{})
+	db.Where("crawl_job_id IN ?", ids).Delete(&CrawlSchedule{})
+	db.Where("crawl_job_id IN ?", ids).Delete(&CrawlRun{})
+	db.Where("crawl_job_id IN ?", ids).Delete(&AlertSubscription{})
+	db.Where("crawl_job_id IN ?", ids).Delete(&Alert{})
+
+	// Delete crawl jobs
+	result := db.Where("id IN ? AND user_id = ?", ids, userID).Delete(&CrawlJob{})
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Jobs deleted successfully",
 		"deleted": result.RowsAffected,
@@ -530,7 +894,8 @@ func deleteCrawlJobs(c *gin.Context) {
 
 func rerunCrawlJobs(c *gin.Context) {
 	var req struct {
-		IDs []uint `json:"ids" binding:"required"`
+		IDs   []uint `json:"ids" binding:"required"`
+		Fresh bool   `json:"fresh"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -549,37 +914,14 @@ func rerunCrawlJobs(c *gin.Context) {
 	// Reset jobs to queued state
 	for _, job := range jobs {
 		// Stop any running job first
-		if cancelChan, exists := jobCancellations[job.ID]; exists {
-			select {
-			case cancelChan <- true:
-			default:
-			}
-		}
+		jobManager.Cancel(job.ID)
 
-		db.Model(&job).Updates(CrawlJob{
-			Status:        "queued",
-			ErrorMessage:  "",
-			StartedAt:     nil,
-			CompletedAt:   nil,
-			HTMLVersion:   "",
-			PageTitle:     "",
-			H1Count:       0,
-			H2Count:       0,
-			H3Count:       0,
-			H4Count:       0,
-			H5Count:       0,
-			H6Count:       0,
-			InternalLinks: 0,
-			ExternalLinks: 0,
-			BrokenLinks:   0,
-			HasLoginForm:  false,
-			MetaTitle:     "",
-			MetaDescription: "",
-			Canonical:     "",
-		})
+		resetCrawlJob(&job)
+		if req.Fresh {
+			db.Model(&job).Update("fresh_link_checks", true)
+		}
 
-		// Delete old broken links
-		db.Where("crawl_job_id = ?", job.ID).Delete(&BrokenLink{})
+		notifyJobStatus(&job, EventQueued, "Reset for re-run")
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -588,16 +930,65 @@ func rerunCrawlJobs(c *gin.Context) {
 	})
 }
 
+// resetCrawlJob clears a job's results so it can be crawled again
+func resetCrawlJob(job *CrawlJob) {
+	db.Model(job).Updates(CrawlJob{
+		Status:          "queued",
+		ErrorMessage:    "",
+		StartedAt:       nil,
+		CompletedAt:     nil,
+		HTMLVersion:     "",
+		PageTitle:       "",
+		H1Count:         0,
+		H2Count:         0,
+		H3Count:         0,
+		H4Count:         0,
+		H5Count:         0,
+		H6Count:         0,
+		InternalLinks:   0,
+		ExternalLinks:   0,
+		BrokenLinks:     0,
+		HasLoginForm:    false,
+		MetaTitle:       "",
+		MetaDescription: "",
+		Canonical:       "",
+	})
+
+	db.Model(job).Updates(map[string]interface{}{
+		"error_code":       "",
+		"pages_crawled":    0,
+		"skipped_links":    0,
+		"broken_assets":    0,
+		"queued_at":        nil,
+		"current_run_id":   nil,
+		"status_code":      0,
+		"content_type":     "",
+		"resource_type":    "",
+		"content_length":   0,
+		"response_headers": "null",
+		"content_hash":     "",
+		"content_changed":  false,
+		"region_hashes":    "null",
+		"changed_regions":  "null",
+	})
+
+	// Earlier runs keep their results. Rows stored before run history existed
+	// belong to no run, so they are cleared as before.
+	db.Where("crawl_job_id = ? AND crawl_run_id = ?", job.ID, 0).Delete(&BrokenLink{})
+	db.Where("crawl_job_id = ? AND crawl_run_id = ?", job.ID, 0).Delete(&SkippedLink{})
+	db.Where("crawl_job_id = ? AND crawl_run_id = ?", job.ID, 0).Delete(&CrawlPage{})
+}
+
 func LoggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		c.Next()
 		duration := time.Since(start)
-		log.Printf("[%s] %s %s - %d (%v)", 
-			c.ClientIP(), 
-			c.Request.Method, 
-			c.Request.URL.Path, 
-			c.Writer.Status(), 
+		log.Printf("[%s] %s %s - %d (%v)",
+			c.ClientIP(),
+			c.Request.Method,
+			c.Request.URL.Path,
+			c.Writer.Status(),
 			duration)
 	}
 }
@@ -605,7 +996,16 @@ func LoggerMiddleware() gin.HandlerFunc {
 func main() {
 	// Initialize database
 	initDB()
-	 
+
+	// Start crawl workers; jobs queued before a restart are picked up again
+	jobQueue.Start()
+
+	// Deliver pending webhooks, including retries left over from a restart
+	webhookDispatcher.Start(jobManager.Done())
+
+	// Requeue jobs on their schedules
+	scheduler.Start(jobManager.Done())
+
 	// Create Gin router
 	r := gin.Default()
 
@@ -633,10 +1033,39 @@ func main() {
 		api.POST("/urls", addURL)
 		api.GET("/urls", getCrawlJobs)
 		api.GET("/urls/:id", getCrawlJobDetails)
+		api.GET("/urls/:id/events", streamJobEvents)
+		api.GET("/urls/:id/runs", getCrawlRuns)
+		api.GET("/urls/:id/runs/diff", getCrawlRunDiff)
+		api.GET("/urls/:id/runs/:run_id", getCrawlRun)
 		api.POST("/urls/:id/start", startCrawl)
 		api.POST("/urls/:id/stop", stopCrawl)
 		api.DELETE("/urls", deleteCrawlJobs)
 		api.POST("/urls/rerun", rerunCrawlJobs)
+		api.POST("/sitemaps/import", importSitemap)
+		api.GET("/queue", getQueueStatus)
+		api.GET("/events", streamJobEvents)
+		api.POST("/schedules", createSchedule)
+		api.GET("/schedules", getSchedules)
+		api.GET("/schedules/:id", getSchedule)
+		api.POST("/schedules/:id/pause", pauseSchedule)
+		api.POST("/schedules/:id/resume", resumeSchedule)
+		api.DELETE("/schedules/:id", deleteSchedule)
+		api.POST("/alerts/subscriptions", createAlertSubscription)
+		api.GET("/alerts/subscriptions", getAlertSubscriptions)
+		api.DELETE("/alerts/subscriptions/:id", deleteAlertSubscription)
+		api.GET("/alerts", getAlerts)
+		api.POST("/alerts/:id/acknowledge", acknowledgeAlert)
+		api.POST("/profiles", createProfile)
+		api.GET("/profiles", getProfiles)
+		api.GET("/profiles/:id", getProfile)
+		api.PUT("/profiles/:id", updateProfile)
+		api.DELETE("/profiles/:id", deleteProfile)
+		api.POST("/webhooks", createWebhook)
+		api.GET("/webhooks", getWebhooks)
+		api.DELETE("/webhooks/:id", deleteWebhook)
+		api.GET("/webhooks/:id/deliveries", getWebhookDeliveries)
+		api.GET("/webhooks/:id/deliveries/:delivery_id", getWebhookDelivery)
+		api.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", redeliverWebhook)
 	}
 
 	// Health check
@@ -645,8 +1074,36 @@ func main() {
 	})
 
 	port := getEnv("PORT", "8081")
-	log.Printf("Server starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+	// Event streams never finish on their own, so end them when shutting down
+	srv.RegisterOnShutdown(eventBroker.Close)
+
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// Wait for SIGINT/SIGTERM, then shut down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Println("Shutting down server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("HTTP server shutdown error:", err)
+	}
+
+	// Running jobs are cancelled and put back on the queue for the next start
+	if err := jobManager.Shutdown(shutdownCtx); err != nil {
+		log.Println("Timed out waiting for crawl jobs to stop:", err)
 	}
-}
\ No newline at end of file
+	log.Println("Server stopped")
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// How URLNormalization treats a trailing slash in the path
+const (
+	TrailingSlashKeep  = "keep"
+	TrailingSlashStrip = "strip"
+	TrailingSlashAdd   = "add"
+)
+
+// Most extra query parameters a job may strip
+const maxStripParams = 50
+
+// Query parameters that only track where a visitor came from. Names ending in
+// "*" match as a prefix.
+var trackingParams = []string{"utm_*", "gclid", "dclid", "fbclid", "msclkid", "yclid", "mc_cid", "mc_eid"}
+
+// URLNormalization configures how discovered URLs are normalized before they
+// are compared, counted, checked or stored. The zero value applies every
+// normalization except changing trailing slashes; each field turns one off.
+type URLNormalization struct {
+	KeepFragments       bool     `json:"keep_fragments"`
+	KeepDefaultPorts    bool     `json:"keep_default_ports"`
+	KeepHostCase        bool     `json:"keep_host_case"`
+	KeepDuplicateParams bool     `json:"keep_duplicate_params"`
+	KeepTrackingParams  bool     `json:"keep_tracking_params"`
+	KeepParamOrder      bool     `json:"keep_param_order"`
+	TrailingSlash       string   `json:"trailing_slash,omitempty"` // keep (default), strip, add
+	StripParams         []string `json:"strip_params,omitempty"`   // extra parameter names, "*" suffix matches a prefix
+}
+
+// Validate checks the options given for a job
+func (n URLNormalization) Validate() error {
+	switch n.TrailingSlash {
+	case "", TrailingSlashKeep, TrailingSlashStrip, TrailingSlashAdd:
+	default:
+		return fmt.Errorf("trailing_slash must be '%s', '%s' or '%s'", TrailingSlashKeep, TrailingSlashStrip, TrailingSlashAdd)
+	}
+	if len(n.StripParams) > maxStripParams {
+		return fmt.Errorf("at most %d strip_params are allowed", maxStripParams)
+	}
+	for _, name := range n.StripParams {
+		if strings.TrimSuffix(name, "*") == "" {
+			return fmt.Errorf("strip_params entries must name a parameter")
+		}
+	}
+	return nil
+}
+
+// Normalize returns a normalized copy of an absolute URL. The scheme is
+// lowercased, an empty path becomes "/" and an empty query is dropped; the
+// other steps follow the options.
+func (n URLNormalization) Normalize(u *url.URL) *url.URL {
+	v := *u
+	v.Scheme = strings.ToLower(v.Scheme)
+	if v.Opaque != "" || v.Host == "" {
+		return &v
+	}
+
+	host := v.Hostname()
+	port := v.Port()
+	if !n.KeepHostCase {
+		host = strings.ToLower(host)
+	}
+	if !n.KeepDefaultPorts && ((v.Scheme == "http" && port == "80") || (v.Scheme == "https" && port == "443")) {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	v.Host = host
+	if port != "" {
+		v.Host = host + ":" + port
+	}
+
+	if v.Path == "" {
+		v.Path = "/"
+		v.RawPath = ""
+	}
+	v.Path = n.trailingSlash(v.Path)
+	if v.RawPath != "" {
+		v.RawPath = n.trailingSlash(v.RawPath)
+	}
+
+	if !n.KeepFragments {
+		v.Fragment = ""
+		v.RawFragment = ""
+	}
+	if v.RawQuery != "" {
+		v.RawQuery = n.normalizeQuery(v.RawQuery)
+	}
+	v.ForceQuery = false
+	return &v
+}
+
+// trailingSlash adds or strips the trailing slash of a path. The root path
+// keeps its slash, and a slash is only added to paths whose last segment has
+// no file extension.
+func (n URLNormalization) trailingSlash(p string) string {
+	if p == "/" {
+		return p
+	}
+	switch n.TrailingSlash {
+	case TrailingSlashStrip:
+		if trimmed := strings.TrimRight(p, "/"); trimmed != "" {
+			return trimmed
+		}
+		return "/"
+	case TrailingSlashAdd:
+		if !strings.HasSuffix(p, "/") && path.Ext(p) == "" {
+			return p + "/"
+		}
+	}
+	return p
+}
+
+// normalizeQuery drops tracking, stripped and repeated parameters and sorts
+// the rest by name. Parameters are compared and kept in their encoded form,
+// and the order of values given for the same name is preserved.
+func (n URLNormalization) normalizeQuery(rawQuery string) string {
+	type param struct{ name, raw string }
+	var params []param
+	seen := make(map[string]bool)
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(raw, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if (!n.KeepTrackingParams && matchesParam(name, trackingParams)) || matchesParam(name, n.StripParams) {
+			continue
+		}
+		if !n.KeepDuplicateParams {
+			if seen[raw] {
+				continue
+			}
+			seen[raw] = true
+		}
+		params = append(params, param{name: name, raw: raw})
+	}
+
+	if !n.KeepParamOrder {
+		sort.SliceStable(params, func(i, j int) bool { return params[i].name < params[j].name })
+	}
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.raw
+	}
+	return strings.Join(parts, "&")
+}
+
+// matchesParam reports whether a parameter name matches any of the patterns,
+// ignoring case
+func matchesParam(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeURL normalizes a URL string, returning it unchanged if it cannot be
+// parsed
+func normalizeURL(rawURL string, n URLNormalization) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return n.Normalize(u).String()
+}
+
+// uniqueLinks returns the first occurrence of every link, and how often each
+// appears. A URL used both as a link and as an asset is kept once for each.
+func uniqueLinks(links []LinkInfo) ([]LinkInfo, map[string]int) {
+	var unique []LinkInfo
+	occurrences := make(map[string]int)
+	for _, link := range links {
+		key := linkKey(link)
+		if occurrences[key] == 0 {
+			unique = append(unique, link)
+		}
+		occurrences[key]++
+	}
+	return unique, occurrences
+}
+
+func linkKey(link LinkInfo) string {
+	return link.AssetType + " " + link.URL
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name string
+		n    URLNormalization
+		raw  string
+		want string
+	}{
+		{"defaults", URLNormalization{}, "HTTP://Example.COM:80?b=2&utm_source=x&a=1&b=2#top", "http://example.com/?a=1&b=2"},
+		{"https default port", URLNormalization{}, "https://example.com:443/a", "https://example.com/a"},
+		{"other port kept", URLNormalization{}, "https://example.com:8443/a", "https://example.com:8443/a"},
+		{"ipv6 host", URLNormalization{}, "http://[::1]:80/a", "http://[::1]/a"},
+		{"ipv6 host with port", URLNormalization{}, "http://[::1]:8080/a", "http://[::1]:8080/a"},
+		{"empty query dropped", URLNormalization{}, "https://example.com/a?", "https://example.com/a"},
+		{"query becomes empty", URLNormalization{}, "https://example.com/a?utm_medium=x&fbclid=y", "https://example.com/a"},
+		{"duplicate values preserved in order", URLNormalization{}, "https://example.com/?b=2&a=1&b=1", "https://example.com/?a=1&b=2&b=1"},
+		{"encoded names", URLNormalization{}, "https://example.com/?%75tm_source=x&q=a%20b", "https://example.com/?q=a%20b"},
+		{"opaque untouched", URLNormalization{}, "MAILTO:Someone@Example.com", "mailto:Someone@Example.com"},
+		{"keep fragments", URLNormalization{KeepFragments: true}, "https://example.com/a#top", "https://example.com/a#top"},
+		{"keep default ports", URLNormalization{KeepDefaultPorts: true}, "https://example.com:443/a", "https://example.com:443/a"},
+		{"keep host case", URLNormalization{KeepHostCase: true}, "https://Example.com/a", "https://Example.com/a"},
+		{"keep duplicate params", URLNormalization{KeepDuplicateParams: true}, "https://example.com/?a=1&a=1", "https://example.com/?a=1&a=1"},
+		{"keep tracking params", URLNormalization{KeepTrackingParams: true}, "https://example.com/?utm_source=x", "https://example.com/?utm_source=x"},
+		{"keep param order", URLNormalization{KeepParamOrder: true}, "https://example.com/?b=1&a=2", "https://example.com/?b=1&a=2"},
+		{"strip params", URLNormalization{StripParams: []string{"session*", "Ref"}}, "https://example.com/?sessionid=1&ref=x&q=1", "https://example.com/?q=1"},
+		{"strip slash", URLNormalization{TrailingSlash: TrailingSlashStrip}, "https://example.com/docs//", "https://example.com/docs"},
+		{"strip slash keeps root", URLNormalization{TrailingSlash: TrailingSlashStrip}, "https://example.com/", "https://example.com/"},
+		{"add slash", URLNormalization{TrailingSlash: TrailingSlashAdd}, "https://example.com/docs", "https://example.com/docs/"},
+		{"add slash skips files", URLNormalization{TrailingSlash: TrailingSlashAdd}, "https://example.com/docs/a.pdf", "https://example.com/docs/a.pdf"},
+		{"add slash to encoded path", URLNormalization{TrailingSlash: TrailingSlashAdd}, "https://example.com/a%2Fb", "https://example.com/a%2Fb/"},
+		{"unparseable", URLNormalization{}, "http://[::1", "http://[::1"},
+	}
+	for _, tt := range tests {
+		if got := normalizeURL(tt.raw, tt.n); got != tt.want {
+			t.Errorf("%s: normalizeURL(%q) = %q, want %q", tt.name, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestURLNormalizationValidate(t *testing.T) {
+	valid := []URLNormalization{
+		{},
+		{TrailingSlash: TrailingSlashKeep},
+		{TrailingSlash: TrailingSlashAdd, StripParams: []string{"ref", "session*"}},
+	}
+	for _, n := range valid {
+		if err := n.Validate(); err != nil {
+			t.Errorf("Validate(%+v): %v", n, err)
+		}
+	}
+
+	invalid := []URLNormalization{
+		{TrailingSlash: "remove"},
+		{StripParams: []string{"*"}},
+		{StripParams: []string{""}},
+		{StripParams: make([]string, maxStripParams+1)},
+	}
+	for _, n := range invalid {
+		if err := n.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want an error", n)
+		}
+	}
+}
+
+func TestUniqueLinks(t *testing.T) {
+	links := []LinkInfo{
+		{URL: "https://example.com/a"},
+		{URL: "https://example.com/b"},
+		{URL: "https://example.com/a"},
+		{URL: "https://example.com/a", AssetType: AssetImage},
+	}
+	unique, occurrences := uniqueLinks(links)
+	if len(unique) != 3 {
+		t.Errorf("got %d unique links, want 3", len(unique))
+	}
+	want := map[string]int{
+		" https://example.com/a":              2,
+		" https://example.com/b":              1,
+		AssetImage + " https://example.com/a": 1,
+	}
+	if !reflect.DeepEqual(occurrences, want) {
+		t.Errorf("occurrences = %v, want %v", occurrences, want)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// How a crawl follows redirects
+const (
+	RedirectFollow   = "follow"
+	RedirectSameHost = "same_host"
+	RedirectNone     = "none"
+)
+
+const (
+	defaultPageTimeoutSeconds = 30
+	maxPageTimeoutSeconds     = 300
+	maxLinkTimeoutSeconds     = 120
+	defaultLinkConcurrency    = 10
+	maxLinkConcurrency        = 50
+	maxRedirectLimit          = 20
+	// Most custom headers or cookies a crawl may send
+	maxSettingsEntries = 50
+)
+
+// Headers a crawl may not set itself. Range and Content-Type are set by link
+// checks and login forms.
+var reservedHeaders = map[string]bool{
+	"Host":                true,
+	"Content-Length":      true,
+	"Transfer-Encoding":   true,
+	"Connection":          true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+	"Range":               true,
+	"Content-Type":        true,
+}
+
+// CrawlSettings configures how a job fetches pages and checks links. A job's
+// own settings override those of its profile, which override the server
+// defaults. Numbers and switches are pointers so that an explicit 0 or false
+// overrides the level below while nil is left to it; the other fields are
+// left to the level below when empty. The settings a run used are stored on
+// the run.
+type CrawlSettings struct {
+	UserAgent          string           `gorm:"type:varchar(255)" json:"user_agent"`
+	PageTimeoutSeconds *int             `json:"page_timeout_seconds"`
+	LinkTimeoutSeconds *int             `json:"link_timeout_seconds"`
+	LinkConcurrency    *int             `json:"link_concurrency"`                        // links checked at once per page
+	HostConcurrency    *int             `json:"host_concurrency"`                        // 0 uses HOST_MAX_CONCURRENCY
+	HostIntervalMS     *int             `json:"host_interval_ms"`                        // 0 uses HOST_REQUEST_INTERVAL_MS
+	RedirectPolicy     string           `gorm:"type:varchar(20)" json:"redirect_policy"` // follow, same_host, none
+	MaxRedirects       *int             `json:"max_redirects"`
+	Headers            secretValues     `gorm:"type:text" json:"headers,omitempty"` // sent to hosts in scope, stored encrypted
+	Cookies            secretValues     `gorm:"type:text" json:"cookies,omitempty"` // sent to hosts in scope, stored encrypted
+	Scope              SiteScope        `gorm:"serializer:json;type:text" json:"scope"`
+	Normalization      URLNormalization `gorm:"serializer:json;type:text" json:"normalization"`
+	URLRules           []URLRule        `gorm:"serializer:json;type:text" json:"url_rules"`
+	SkipLinkChecks     *bool            `json:"skip_link_checks"`
+	SkipExternalChecks *bool            `json:"skip_external_checks"`
+	CheckAssets        *bool            `json:"check_assets"` // also check images, scripts, stylesheets and frames
+	BypassLinkCache    *bool            `json:"bypass_link_cache"`
+}
+
+// ptr returns a pointer to v, for setting numbers and switches
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// deref returns the value p points to, or the zero value if p is nil
+func deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
+
+// Override returns the settings with every non-nil, non-empty field of over
+// applied. Headers and cookies are merged, with over taking precedence.
+func (s CrawlSettings) Override(over CrawlSettings) CrawlSettings {
+	merged := s
+	mv := reflect.ValueOf(&merged).Elem()
+	ov := reflect.ValueOf(over)
+	for i := 0; i < ov.NumField(); i++ {
+		field := ov.Field(i)
+		if field.IsZero() {
+			continue
+		}
+		if field.Kind() == reflect.Map && !mv.Field(i).IsNil() {
+			combined := reflect.MakeMap(field.Type())
+			for _, m := range []reflect.Value{mv.Field(i), field} {
+				iter := m.MapRange()
+				for iter.Next() {
+					combined.SetMapIndex(iter.Key(), iter.Value())
+				}
+			}
+			mv.Field(i).Set(combined)
+			continue
+		}
+		mv.Field(i).Set(field)
+	}
+	return merged
+}
+
+// withDefaults fills the numbers that are unset or 0 from defaults. A 0 given
+// for a job stands for the server default, even where its profile sets one.
+func (s CrawlSettings) withDefaults(defaults CrawlSettings) CrawlSettings {
+	sv := reflect.ValueOf(&s).Elem()
+	dv := reflect.ValueOf(defaults)
+	for i := 0; i < sv.NumField(); i++ {
+		field := sv.Field(i)
+		if field.Type() != reflect.TypeOf((*int)(nil)) {
+			continue
+		}
+		if field.IsNil() || field.Elem().Int() == 0 {
+			field.Set(dv.Field(i))
+		}
+	}
+	return s
+}
+
+// Validate checks settings given for a profile or job
+func (s CrawlSettings) Validate() error {
+	if len(s.UserAgent) > 255 || strings.ContainsAny(s.UserAgent, "\r\n") {
+		return fmt.Errorf("user_agent must be a single line of at most 255 characters")
+	}
+	limits := []struct {
+		name  string
+		value *int
+		max   int
+	}{
+		{"page_timeout_seconds", s.PageTimeoutSeconds, maxPageTimeoutSeconds},
+		{"link_timeout_seconds", s.LinkTimeoutSeconds, maxLinkTimeoutSeconds},
+		{"link_concurrency", s.LinkConcurrency, maxLinkConcurrency},
+		{"host_concurrency", s.HostConcurrency, maxHostConcurrency},
+		{"host_interval_ms", s.HostIntervalMS, maxHostIntervalMS},
+		{"max_redirects", s.MaxRedirects, maxRedirectLimit},
+	}
+	for _, limit := range limits {
+		if v := deref(limit.value); v < 0 || v > limit.max {
+			return fmt.Errorf("%s must be between 0 and %d", limit.name, limit.max)
+		}
+	}
+	switch s.RedirectPolicy {
+	case "", RedirectFollow, RedirectSameHost, RedirectNone:
+	default:
+		return fmt.Errorf("redirect_policy must be '%s', '%s' or '%s'", RedirectFollow, RedirectSameHost, RedirectNone)
+	}
+
+	if len(s.Headers) > maxSettingsEntries {
+		return fmt.Errorf("at most %d headers are allowed", maxSettingsEntries)
+	}
+	for name, value := range s.Headers {
+		if !isToken(name) || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid header %q", name)
+		}
+		if reservedHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("header %q cannot be set; use cookies for Cookie", name)
+		}
+	}
+	if len(s.Cookies) > maxSettingsEntries {
+		return fmt.Errorf("at most %d cookies are allowed", maxSettingsEntries)
+	}
+	for name, value := range s.Cookies {
+		if !isToken(name) || strings.ContainsAny(value, ";\r\n\" ") {
+			return fmt.Errorf("invalid cookie %q", name)
+		}
+	}
+
+	if err := s.Normalization.Validate(); err != nil {
+		return err
+	}
+	if err := s.Scope.Validate(); err != nil {
+		return err
+	}
+	if _, err := compileURLRules(s.URLRules); err != nil {
+		return err
+	}
+	return nil
+}
+
+// hasSecrets reports whether the settings carry headers or cookies, which can
+// only be stored with CREDENTIALS_KEY set
+func (s CrawlSettings) hasSecrets() bool {
+	return len(s.Headers) > 0 || len(s.Cookies) > 0
+}
+
+// checkSecrets returns an error if the stored headers or cookies could not be
+// decrypted
+func (s CrawlSettings) checkSecrets() error {
+	if s.Headers.sealed() {
+		return fmt.Errorf("headers cannot be decrypted with the configured key")
+	}
+	if s.Cookies.sealed() {
+		return fmt.Errorf("cookies cannot be decrypted with the configured key")
+	}
+	return nil
+}
+
+// private reports whether fetches under these settings may see links
+// differently from a crawl with the defaults, because they send their own
+// cookies, headers or User-Agent, or follow redirects differently
+func (s CrawlSettings) private(defaultUserAgent string) bool {
+	if len(s.Cookies) > 0 || len(s.Headers) > 0 || (s.UserAgent != "" && s.UserAgent != defaultUserAgent) {
+		return true
+	}
+	if s.RedirectPolicy != "" && s.RedirectPolicy != RedirectFollow {
+		return true
+	}
+	// 0 follows the default number of redirects
+	max := deref(s.MaxRedirects)
+	return max != 0 && max != maxRedirects
+}
+
+// redirectPolicy returns the redirects fetches under these settings follow
+func (s CrawlSettings) redirectPolicy() redirectPolicy {
+	return redirectPolicy{
+		max:      deref(s.MaxRedirects),
+		sameHost: s.RedirectPolicy == RedirectSameHost,
+		none:     s.RedirectPolicy == RedirectNone,
+	}
+}
+
+func (s CrawlSettings) pageTimeout() time.Duration {
+	return time.Duration(deref(s.PageTimeoutSeconds)) * time.Second
+}
+
+func (s CrawlSettings) linkTimeout() time.Duration {
+	return time.Duration(deref(s.LinkTimeoutSeconds)) * time.Second
+}
+
+// isToken reports whether s is a valid HTTP header or cookie name
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultSettings returns the settings used where neither a job nor its
+// profile sets a value
+func (cs *CrawlerService) defaultSettings() CrawlSettings {
+	return CrawlSettings{
+		UserAgent:          cs.userAgent,
+		PageTimeoutSeconds: ptr(defaultPageTimeoutSeconds),
+		LinkTimeoutSeconds: ptr(int(linkCheckTimeout / time.Second)),
+		LinkConcurrency:    ptr(defaultLinkConcurrency),
+		HostConcurrency:    ptr(cs.hosts.defaults.MaxConcurrent),
+		HostIntervalMS:     ptr(int(cs.hosts.defaults.MinInterval / time.Millisecond)),
+		RedirectPolicy:     RedirectFollow,
+		MaxRedirects:       ptr(maxRedirects),
+		SkipLinkChecks:     ptr(false),
+		SkipExternalChecks: ptr(false),
+		CheckAssets:        ptr(false),
+		BypassLinkCache:    ptr(false),
+	}
+}
+
+// resolveSettings returns the settings a job crawls with: the server defaults,
+// overridden by the job's profile, overridden by the job's own settings
+func (cs *CrawlerService) resolveSettings(job *CrawlJob) CrawlSettings {
+	defaults := cs.defaultSettings()
+	settings := defaults
+	if job.ProfileID != nil {
+		var profile CrawlProfile
+		if err := cs.db.Where("id = ? AND user_id = ?", *job.ProfileID, job.UserID).First(&profile).Error; err == nil {
+			settings = settings.Override(profile.Settings)
+		}
+	}
+	return settings.Override(job.CrawlSettings).withDefaults(defaults)
+}
+
+// CrawlProfile is a named set of crawl settings a user can apply to jobs
+type CrawlProfile struct {
+	ID          uint          `gorm:"primaryKey" json:"id"`
+	UserID      uint          `gorm:"not null;index" json:"user_id"`
+	Name        string        `gorm:"type:varchar(100);not null" json:"name"`
+	Description string        `gorm:"type:text" json:"description"`
+	Settings    CrawlSettings `gorm:"embedded;embeddedPrefix:settings_" json:"settings"`
+	gorm.Model
+}
+
+type profileRequest struct {
+	Name        string        `json:"name" binding:"required"`
+	Description string        `json:"description"`
+	Settings    CrawlSettings `json:"settings"`
+}
+
+// bindProfileRequest reads and validates a profile from the request body,
+// responding with 400 if it is invalid
+func bindProfileRequest(c *gin.Context, excludeID uint) (*profileRequest, bool) {
+	var req profileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be between 1 and 100 characters"})
+		return nil, false
+	}
+	if err := req.Settings.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if req.Settings.hasSecrets() && credentialsKey == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Credential storage is not configured"})
+		return nil, false
+	}
+
+	var count int64
+	db.Model(&CrawlProfile{}).Where("user_id = ? AND name = ? AND id <> ?", c.GetUint("user_id"), req.Name, excludeID).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A profile with this name already exists"})
+		return nil, false
+	}
+	return &req, true
+}
+
+func createProfile(c *gin.Context) {
+	req, ok := bindProfileRequest(c, 0)
+	if !ok {
+		return
+	}
+
+	profile := CrawlProfile{
+		UserID:      c.GetUint("user_id"),
+		Name:        req.Name,
+		Description: req.Description,
+		Settings:    req.Settings,
+	}
+	if err := db.Create(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create profile"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, profile)
+}
+
+func getProfiles(c *gin.Context) {
+	var profiles []CrawlProfile
+	db.Where("user_id = ?", c.GetUint("user_id")).Order("name asc").Find(&profiles)
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles})
+}
+
+func getProfile(c *gin.Context) {
+	profile, ok := findUserProfile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// updateProfile replaces a profile's name, description and settings. Jobs
+// using it pick up the change on their next run.
+func updateProfile(c *gin.Context) {
+	profile, ok := findUserProfile(c)
+	if !ok {
+		return
+	}
+	req, ok := bindProfileRequest(c, profile.ID)
+	if !ok {
+		return
+	}
+
+	// Values returned redacted keep what is stored
+	req.Settings.Headers.restoreRedacted(profile.Settings.Headers)
+	req.Settings.Cookies.restoreRedacted(profile.Settings.Cookies)
+
+	profile.Name = req.Name
+	profile.Description = req.Description
+	profile.Settings = req.Settings
+	if err := db.Save(profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// deleteProfile deletes a profile. Jobs using it keep their own settings and
+// fall back to the server defaults for the rest.
+func deleteProfile(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	result := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&CrawlProfile{})
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Profile not found"})
+		return
+	}
+	db.Model(&CrawlJob{}).Where("profile_id = ? AND user_id = ?", c.Param("id"), userID).Update("profile_id", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Profile deleted"})
+}
+
+// findUserProfile loads the :id profile of the current user, responding with
+// 404 if it does not exist
+func findUserProfile(c *gin.Context) (*CrawlProfile, bool) {
+	var profile CrawlProfile
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).First(&profile).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Profile not found"})
+		return nil, false
+	}
+	return &profile, true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCrawlSettingsOverride(t *testing.T) {
+	defaults := CrawlSettings{
+		UserAgent:          "Crawler/1.0",
+		PageTimeoutSeconds: ptr(30),
+		LinkConcurrency:    ptr(10),
+		HostIntervalMS:     ptr(250),
+		SkipLinkChecks:     ptr(false),
+		CheckAssets:        ptr(false),
+	}
+	profile := CrawlSettings{
+		UserAgent:          "Monitor/2.0",
+		PageTimeoutSeconds: ptr(60),
+		LinkConcurrency:    ptr(5),
+		Headers:            map[string]string{"X-Env": "staging", "X-Team": "web"},
+		SkipLinkChecks:     ptr(true),
+		CheckAssets:        ptr(true),
+	}
+
+	var job CrawlSettings
+	if err := json.Unmarshal([]byte(`{
+		"page_timeout_seconds": 0,
+		"link_concurrency": null,
+		"headers": {"X-Env": "prod"},
+		"skip_link_checks": false
+	}`), &job); err != nil {
+		t.Fatal(err)
+	}
+
+	got := defaults.Override(profile).Override(job).withDefaults(defaults)
+
+	if got.UserAgent != "Monitor/2.0" {
+		t.Errorf("user_agent = %q, want the profile's", got.UserAgent)
+	}
+	// 0 asks for the server default over the profile's value
+	if deref(got.PageTimeoutSeconds) != 30 {
+		t.Errorf("page_timeout_seconds = %d, want the default 30", deref(got.PageTimeoutSeconds))
+	}
+	// null falls through to the profile
+	if deref(got.LinkConcurrency) != 5 {
+		t.Errorf("link_concurrency = %d, want the profile's 5", deref(got.LinkConcurrency))
+	}
+	if deref(got.HostIntervalMS) != 250 {
+		t.Errorf("host_interval_ms = %d, want the default 250", deref(got.HostIntervalMS))
+	}
+	// false turns off a switch the profile turns on
+	if deref(got.SkipLinkChecks) {
+		t.Error("skip_link_checks is still on, want the job's false")
+	}
+	if !deref(got.CheckAssets) {
+		t.Error("check_assets is off, want the profile's true")
+	}
+	wantHeaders := secretValues{"X-Env": "prod", "X-Team": "web"}
+	if !reflect.DeepEqual(got.Headers, wantHeaders) {
+		t.Errorf("headers = %v, want %v", got.Headers, wantHeaders)
+	}
+	if profile.Headers["X-Env"] != "staging" {
+		t.Error("merging headers modified the profile's")
+	}
+}
+
+func TestCrawlSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings CrawlSettings
+		valid    bool
+	}{
+		{"empty", CrawlSettings{}, true},
+		{"zero limits", CrawlSettings{PageTimeoutSeconds: ptr(0), MaxRedirects: ptr(0)}, true},
+		{"maximum limits", CrawlSettings{LinkConcurrency: ptr(maxLinkConcurrency), MaxRedirects: ptr(maxRedirectLimit)}, true},
+		{"negative timeout", CrawlSettings{PageTimeoutSeconds: ptr(-1)}, false},
+		{"too many redirects", CrawlSettings{MaxRedirects: ptr(maxRedirectLimit + 1)}, false},
+		{"too much concurrency", CrawlSettings{HostConcurrency: ptr(maxHostConcurrency + 1)}, false},
+		{"multi-line user agent", CrawlSettings{UserAgent: "a\r\nX-Evil: 1"}, false},
+		{"unknown redirect policy", CrawlSettings{RedirectPolicy: "sometimes"}, false},
+		{"header", CrawlSettings{Headers: map[string]string{"X-Env": "staging"}}, true},
+		{"reserved header", CrawlSettings{Headers: map[string]string{"host": "example.com"}}, false},
+		{"invalid header name", CrawlSettings{Headers: map[string]string{"X Env": "staging"}}, false},
+		{"cookie", CrawlSettings{Cookies: map[string]string{"consent": "yes"}}, true},
+		{"cookie value with semicolon", CrawlSettings{Cookies: map[string]string{"consent": "yes; path=/"}}, false},
+		{"invalid url rule", CrawlSettings{URLRules: []URLRule{{Pattern: "/a"}}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.settings.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: Validate() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestCrawlSettingsPrivate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings CrawlSettings
+		want     bool
+	}{
+		{"defaults", CrawlSettings{UserAgent: "Crawler/1.0", CheckAssets: ptr(true)}, false},
+		{"no user agent", CrawlSettings{}, false},
+		{"own user agent", CrawlSettings{UserAgent: "Monitor/2.0"}, true},
+		{"headers", CrawlSettings{Headers: map[string]string{"X-Api-Key": "key"}}, true},
+		{"cookies", CrawlSettings{Cookies: map[string]string{"consent": "yes"}}, true},
+		{"follow redirects", CrawlSettings{RedirectPolicy: RedirectFollow, MaxRedirects: ptr(maxRedirects)}, false},
+		{"default max redirects", CrawlSettings{MaxRedirects: ptr(0)}, false},
+		{"same host redirects", CrawlSettings{RedirectPolicy: RedirectSameHost}, true},
+		{"no redirects", CrawlSettings{RedirectPolicy: RedirectNone}, true},
+		{"fewer redirects", CrawlSettings{MaxRedirects: ptr(2)}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.settings.private("Crawler/1.0"); got != tt.want {
+			t.Errorf("%s: private = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
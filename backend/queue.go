@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// JobQueueConfig configures the worker pool and job leases
+type JobQueueConfig struct {
+	Workers      int
+	PollInterval time.Duration
+	// How often a worker refreshes heartbeat_at on the job it is running
+	HeartbeatInterval time.Duration
+	// A running job whose heartbeat is older than this is considered abandoned
+	LeaseTimeout time.Duration
+	// Abandoned jobs are requeued until they have been attempted this many times
+	MaxAttempts int
+}
+
+// JobQueue runs queued crawl jobs on a fixed pool of workers. The queue lives
+// in the crawl_jobs table: a job is waiting when its status is "queued" and
+// queued_at is set, so pending work survives a restart.
+type JobQueue struct {
+	db         *gorm.DB
+	crawler    *CrawlerService
+	jobs       *JobManager
+	config     JobQueueConfig
+	instanceID string
+	wake       chan struct{}
+}
+
+// NewJobQueue creates a job queue
+func NewJobQueue(db *gorm.DB, crawler *CrawlerService, jobs *JobManager, config JobQueueConfig) *JobQueue {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	return &JobQueue{
+		db:         db,
+		crawler:    crawler,
+		jobs:       jobs,
+		config:     config,
+		instanceID: newInstanceID(),
+		wake:       make(chan struct{}, config.Workers),
+	}
+}
+
+// newInstanceID identifies this server process on the jobs it runs
+func newInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// Start recovers jobs abandoned by a previous process, then launches the
+// workers and the stale job reaper
+func (q *JobQueue) Start() {
+	q.recoverStaleJobs()
+
+	log.Printf("Starting %d crawl workers (instance %s)", q.config.Workers, q.instanceID)
+	for i := 0; i < q.config.Workers; i++ {
+		go q.work(i + 1)
+	}
+	go q.reap()
+}
+
+// Enqueue puts a job at the back of the queue and wakes an idle worker. The
+// trigger is recorded on the run the job's next execution creates.
+func (q *JobQueue) Enqueue(job *CrawlJob, trigger string) error {
+	now := time.Now()
+	err := q.db.Model(&CrawlJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":        "queued",
+		"queued_at":     &now,
+		"error_message": "",
+		"error_code":    "",
+		"attempts":      0,
+		"triggered_by":  trigger,
+	}).Error
+	if err != nil {
+		return err
+	}
+	notifyJobStatus(job, EventQueued, "")
+	q.Notify()
+	return nil
+}
+
+// Dequeue removes a waiting job from the queue, reporting whether it was waiting
+func (q *JobQueue) Dequeue(job *CrawlJob) bool {
+	completed := time.Now()
+	result := q.db.Model(&CrawlJob{}).
+		Where("id = ? AND status = ? AND queued_at IS NOT NULL", job.ID, "queued").
+		Updates(map[string]interface{}{
+			"status":       "stopped",
+			"queued_at":    nil,
+			"completed_at": &completed,
+		})
+	if result.RowsAffected != 1 {
+		return false
+	}
+	notifyJobStatus(job, EventDequeued, "Removed from queue")
+	return true
+}
+
+// Notify wakes an idle worker to look for queued jobs
+func (q *JobQueue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work runs queued jobs one at a time until the server shuts down
+func (q *JobQueue) work(workerID int) {
+	for {
+		select {
+		case <-q.jobs.Done():
+			return
+		default:
+		}
+
+		job := q.claimNext()
+		if job == nil {
+			select {
+			case <-q.wake:
+			case <-time.After(q.config.PollInterval):
+			case <-q.jobs.Done():
+				return
+			}
+			continue
+		}
+
+		log.Printf("Worker %d picked up job %d (attempt %d)", workerID, job.ID, job.Attempts)
+		ctx, done := q.jobs.Begin(job.ID)
+		stopHeartbeat := q.heartbeat(job.ID)
+		q.crawler.CrawlURL(ctx, job)
+		stopHeartbeat()
+		done()
+	}
+}
+
+// heartbeat keeps the lease on a running job fresh until the returned
+// function is called. When the job is no longer running under this worker,
+// because it was stopped or deleted through another instance, the crawl is
+// cancelled as stopped.
+func (q *JobQueue) heartbeat(jobID uint) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(q.config.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				result := q.db.Model(&CrawlJob{}).
+					Where("id = ? AND worker_id = ? AND status = ?", jobID, q.instanceID, "running").
+					Update("heartbeat_at", time.Now())
+				if result.Error == nil && result.RowsAffected == 0 {
+					log.Printf("Job %d is no longer running on this instance, stopping its crawl", jobID)
+					q.jobs.Cancel(jobID)
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// IsStale reports whether a running job's lease has expired
+func (q *JobQueue) IsStale(job *CrawlJob) bool {
+	if job.HeartbeatAt == nil {
+		return job.StartedAt == nil || time.Since(*job.StartedAt) > q.config.LeaseTimeout
+	}
+	return time.Since(*job.HeartbeatAt) > q.config.LeaseTimeout
+}
+
+// reap periodically looks for running jobs whose worker has gone away
+func (q *JobQueue) reap() {
+	ticker := time.NewTicker(q.config.LeaseTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			q.recoverStaleJobs()
+		case <-q.jobs.Done():
+			return
+		}
+	}
+}
+
+// recoverStaleJobs requeues running jobs whose heartbeat has expired, or marks
+// them errored once they have used up their attempts. Jobs from before
+// heartbeats existed are judged by started_at.
+func (q *JobQueue) recoverStaleJobs() {
+	cutoff := time.Now().Add(-q.config.LeaseTimeout)
+
+	var stale []CrawlJob
+	q.db.Where("status = ? AND (heartbeat_at < ? OR (heartbeat_at IS NULL AND (started_at IS NULL OR started_at < ?)))",
+		"running", cutoff, cutoff).Find(&stale)
+
+	for _, job := range stale {
+		lastSeen := "never"
+		if job.HeartbeatAt != nil {
+			lastSeen = job.HeartbeatAt.Format(time.RFC3339)
+		}
+
+		// Only act if nobody refreshed the lease since we looked
+		query := q.db.Model(&CrawlJob{}).Where("id = ? AND status = ?", job.ID, "running")
+		if job.HeartbeatAt != nil {
+			query = query.Where("heartbeat_at = ?", job.HeartbeatAt)
+		} else {
+			query = query.Where("heartbeat_at IS NULL")
+		}
+
+		job := job
+		var result *gorm.DB
+		if job.Attempts < q.config.MaxAttempts {
+			now := time.Now()
+			message := fmt.Sprintf("Requeued: worker %s stopped sending heartbeats (last heartbeat: %s)", job.WorkerID, lastSeen)
+			result = query.Updates(map[string]interface{}{
+				"status":        "queued",
+				"queued_at":     &now,
+				"worker_id":     "",
+				"error_message": message,
+			})
+			if result.RowsAffected == 1 {
+				log.Printf("Requeued stale job %d (attempt %d of %d, last heartbeat: %s)", job.ID, job.Attempts, q.config.MaxAttempts, lastSeen)
+				closeRun(&job, "error", message)
+				notifyJobStatus(&job, EventQueued, message)
+			}
+		} else {
+			completed := time.Now()
+			message := fmt.Sprintf("Crawl abandoned: worker %s stopped sending heartbeats (last heartbeat: %s) after %d attempts", job.WorkerID, lastSeen, job.Attempts)
+			result = query.Updates(map[string]interface{}{
+				"status":        "error",
+				"completed_at":  &completed,
+				"error_message": message,
+			})
+			if result.RowsAffected == 1 {
+				log.Printf("Marked stale job %d as errored after %d attempts", job.ID, job.Attempts)
+				closeRun(&job, "error", message)
+				notifyJobStatus(&job, EventError, message)
+			}
+		}
+	}
+
+	if len(stale) > 0 {
+		q.Notify()
+	}
+}
+
+// claimNext atomically takes the oldest waiting job, returning nil if none is
+// waiting. Claiming is a conditional update, so a job is never run twice even
+// when workers race for it.
+func (q *JobQueue) claimNext() *CrawlJob {
+	for attempt := 0; attempt < 3; attempt++ {
+		var job CrawlJob
+		err := q.db.Where("status = ? AND queued_at IS NOT NULL", "queued").
+			Order("queued_at asc, id asc").
+			First(&job).Error
+		if err != nil {
+			return nil
+		}
+
+		now := time.Now()
+		result := q.db.Model(&CrawlJob{}).
+			Where("id = ? AND status = ? AND queued_at IS NOT NULL", job.ID, "queued").
+			Updates(map[string]interface{}{
+				"status":       "running",
+				"started_at":   &now,
+				"queued_at":    nil,
+				"worker_id":    q.instanceID,
+				"heartbeat_at": &now,
+				"attempts":     gorm.Expr("attempts + 1"),
+			})
+		if result.Error == nil && result.RowsAffected == 1 {
+			job.Status = "running"
+			job.StartedAt = &now
+			job.QueuedAt = nil
+			job.WorkerID = q.instanceID
+			job.HeartbeatAt = &now
+			job.Attempts++
+			return &job
+		}
+	}
+	return nil
+}
+
+// getQueueStatus reports the state of the user's queued and running jobs,
+// including how recently each running job's worker checked in
+func getQueueStatus(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var queued int64
+	db.Model(&CrawlJob{}).
+		Where("user_id = ? AND status = ? AND queued_at IS NOT NULL", userID, "queued").
+		Count(&queued)
+
+	var running []CrawlJob
+	db.Where("user_id = ? AND status = ?", userID, "running").Order("started_at asc").Find(&running)
+
+	now := time.Now()
+	runningJobs := make([]gin.H, 0, len(running))
+	for _, job := range running {
+		entry := gin.H{
+			"id":            job.ID,
+			"url":           job.URL,
+			"worker_id":     job.WorkerID,
+			"attempts":      job.Attempts,
+			"started_at":    job.StartedAt,
+			"heartbeat_at":  job.HeartbeatAt,
+			"pages_crawled": job.PagesCrawled,
+			"stale":         jobQueue.IsStale(&job),
+		}
+		if job.HeartbeatAt != nil {
+			entry["heartbeat_age_seconds"] = int(now.Sub(*job.HeartbeatAt).Seconds())
+		}
+		runningJobs = append(runningJobs, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"instance_id":   jobQueue.instanceID,
+		"workers":       jobQueue.config.Workers,
+		"queued":        queued,
+		"running":       runningJobs,
+		"lease_seconds": int(jobQueue.config.LeaseTimeout.Seconds()),
+	})
+}
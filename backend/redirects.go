@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Redirects followed before a fetch gives up
+	maxRedirects = 10
+	// Chains with at least this many redirects are flagged as long
+	longRedirectChain = 3
+)
+
+var (
+	errRedirectLoop     = errors.New("redirect loop")
+	errTooManyRedirects = errors.New("too many redirects")
+)
+
+// What a redirect chain was recorded for
+const (
+	RedirectKindPage = "page"
+	RedirectKindLink = "link"
+)
+
+// RedirectHop is one response in a redirect chain
+type RedirectHop struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// RedirectChain records the redirects followed when fetching a page or
+// checking a link during a run. Requests that were not redirected are not
+// recorded.
+type RedirectChain struct {
+	ID           uint          `gorm:"primaryKey" json:"id"`
+	CrawlJobID   uint          `gorm:"not null;index" json:"crawl_job_id"`
+	CrawlRunID   uint          `gorm:"index" json:"crawl_run_id"`
+	Kind         string        `gorm:"type:varchar(10)" json:"kind"` // page, link
+	URL          string        `gorm:"type:text;not null" json:"url"`
+	PageURL      string        `gorm:"type:text" json:"page_url"`
+	FinalURL     string        `gorm:"type:text" json:"final_url"`
+	FinalStatus  int           `json:"final_status"`
+	Redirects    int           `json:"redirects"`
+	Hops         []RedirectHop `gorm:"serializer:json;type:text" json:"hops"`
+	DurationMS   int64         `json:"duration_ms"`
+	LongChain    bool          `json:"long_chain"`
+	HasLoop      bool          `json:"has_loop"`
+	Temporary    bool          `json:"temporary"`
+	CrossHost    bool          `json:"cross_host"`
+	HTTPSUpgrade bool          `json:"https_upgrade"`
+	Error        string        `gorm:"type:text" json:"error,omitempty"`
+	gorm.Model
+}
+
+// redirectTrace is the chain of responses seen while following redirects
+type redirectTrace struct {
+	hops []RedirectHop
+	loop bool
+}
+
+// redirected reports whether the request was redirected at least once
+func (t *redirectTrace) redirected() bool {
+	return len(t.hops) > 1 || (len(t.hops) == 1 && t.hops[0].Location != "")
+}
+
+// chain summarises the trace as a RedirectChain, flagging chains that are
+// long, loop, use temporary redirects, leave the starting host or upgrade
+// from http to https
+func (t *redirectTrace) chain(kind, pageURL string, err error) RedirectChain {
+	first := t.hops[0]
+	last := t.hops[len(t.hops)-1]
+	chain := RedirectChain{
+		Kind:        kind,
+		URL:         first.URL,
+		PageURL:     pageURL,
+		FinalURL:    last.URL,
+		FinalStatus: last.StatusCode,
+		Hops:        t.hops,
+		HasLoop:     t.loop,
+	}
+	if err != nil {
+		chain.Error = err.Error()
+	}
+
+	start, _ := url.Parse(first.URL)
+	for _, hop := range t.hops {
+		chain.DurationMS += hop.DurationMS
+		if hop.Location == "" {
+			continue
+		}
+		chain.Redirects++
+		switch hop.StatusCode {
+		case http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect:
+			chain.Temporary = true
+		}
+		target, parseErr := url.Parse(hop.Location)
+		if parseErr != nil || start == nil {
+			continue
+		}
+		if target.Hostname() != start.Hostname() {
+			chain.CrossHost = true
+		}
+		if from, _ := url.Parse(hop.URL); from != nil && from.Scheme == "http" && target.Scheme == "https" {
+			chain.HTTPSUpgrade = true
+		}
+	}
+	chain.LongChain = chain.Redirects >= longRedirectChain
+	return chain
+}
+
+// redirectPolicy limits the redirects fetchWithRedirects follows
+type redirectPolicy struct {
+	// Redirects followed before giving up; 0 uses maxRedirects
+	max int
+	// Only follow redirects to the same host
+	sameHost bool
+	// Return redirect responses instead of following them
+	none bool
+}
+
+// noRedirects makes a client return redirect responses instead of following
+// them, so fetchWithRedirects can record each hop
+func noRedirects(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// fetchWithRedirects sends a request and follows any redirects the policy
+// allows itself, recording the status, Location and timing of every hop. The
+// client must not follow redirects. A redirect back to a URL already in the
+// chain, or more redirects than the policy's limit, ends the fetch with an
+// error. A redirect the policy does not follow is returned as the response.
+// The trace is returned even when the fetch fails.
+func fetchWithRedirects(ctx context.Context, client *http.Client, method, rawURL string, header http.Header, policy redirectPolicy) (*http.Response, *redirectTrace, error) {
+	trace := &redirectTrace{}
+	seen := make(map[string]bool)
+	limit := policy.max
+	if limit <= 0 {
+		limit = maxRedirects
+	}
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+		if err != nil {
+			return nil, trace, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, trace, err
+		}
+		hop := RedirectHop{
+			URL:        rawURL,
+			StatusCode: resp.StatusCode,
+			DurationMS: time.Since(start).Milliseconds(),
+		}
+		seen[rawURL] = true
+
+		location := resp.Header.Get("Location")
+		if !isRedirectStatus(resp.StatusCode) || location == "" {
+			trace.hops = append(trace.hops, hop)
+			return resp, trace, nil
+		}
+
+		target, err := req.URL.Parse(location)
+		if err != nil {
+			trace.hops = append(trace.hops, hop)
+			return resp, trace, nil
+		}
+		hop.Location = target.String()
+		trace.hops = append(trace.hops, hop)
+		if policy.none || (policy.sameHost && target.Hostname() != req.URL.Hostname()) {
+			return resp, trace, nil
+		}
+
+		// Drain the redirect body so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+
+		if seen[hop.Location] {
+			trace.loop = true
+			return nil, trace, fmt.Errorf("%w at %s", errRedirectLoop, hop.Location)
+		}
+		if len(trace.hops) >= limit {
+			return nil, trace, fmt.Errorf("%w: stopped after %d", errTooManyRedirects, limit)
+		}
+		if target.Scheme != "http" && target.Scheme != "https" {
+			return nil, trace, fmt.Errorf("redirect to unsupported scheme %q", target.Scheme)
+		}
+
+		// Like net/http, only 307 and 308 keep a method other than GET or HEAD
+		if method != http.MethodHead && resp.StatusCode != http.StatusTemporaryRedirect && resp.StatusCode != http.StatusPermanentRedirect {
+			method = http.MethodGet
+		}
+		rawURL = hop.Location
+	}
+}
+
+// lastStatus returns the status of the last response in a trace, or 0
+func lastStatus(trace *redirectTrace) int {
+	if len(trace.hops) == 0 {
+		return 0
+	}
+	return trace.hops[len(trace.hops)-1].StatusCode
+}
+
+func isRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// recordRedirect keeps the chain of a redirected request for the run
+func (s *crawlState) recordRedirect(trace *redirectTrace, kind, pageURL string, err error) {
+	if trace == nil || !trace.redirected() {
+		return
+	}
+	chain := trace.chain(kind, pageURL, err)
+	s.redirectMutex.Lock()
+	s.redirects = append(s.redirects, chain)
+	s.redirectMutex.Unlock()
+}
+
+// saveRedirectChains stores the redirect chains recorded during a crawl on
+// the job's current run
+func (cs *CrawlerService) saveRedirectChains(job *CrawlJob, state *crawlState) {
+	state.redirectMutex.Lock()
+	chains := state.redirects
+	state.redirects = nil
+	state.redirectMutex.Unlock()
+
+	for i := range chains {
+		chains[i].CrawlJobID = job.ID
+		chains[i].CrawlRunID = state.runID()
+		cs.db.Create(&chains[i])
+	}
+}
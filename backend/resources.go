@@ -0,0 +1,86 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Bodies larger than this are truncated before they are parsed or hashed
+const maxResponseSize = 10 << 20
+
+// Resource types a fetched URL is classified as
+const (
+	ResourceHTML       = "html"
+	ResourcePDF        = "pdf"
+	ResourceImage      = "image"
+	ResourceJSON       = "json"
+	ResourceXML        = "xml"
+	ResourceText       = "text"
+	ResourceStylesheet = "stylesheet"
+	ResourceScript     = "script"
+	ResourceVideo      = "video"
+	ResourceAudio      = "audio"
+	ResourceFont       = "font"
+	ResourceOther      = "other"
+)
+
+// Response headers that are not stored with a result
+var ignoredResponseHeaders = map[string]bool{
+	"Set-Cookie": true,
+}
+
+// classifyContent returns the media type of a response and the resource type
+// it belongs to. Responses without a Content-Type are sniffed from the body.
+func classifyContent(contentType string, body []byte) (string, string) {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mediaType, resourceType(mediaType)
+}
+
+// resourceType maps a media type onto a resource type
+func resourceType(mediaType string) string {
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return ResourceHTML
+	case mediaType == "application/pdf":
+		return ResourcePDF
+	case strings.HasPrefix(mediaType, "image/"):
+		return ResourceImage
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return ResourceJSON
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return ResourceXML
+	case mediaType == "text/css":
+		return ResourceStylesheet
+	case strings.Contains(mediaType, "javascript") || mediaType == "application/ecmascript":
+		return ResourceScript
+	case strings.HasPrefix(mediaType, "video/"):
+		return ResourceVideo
+	case strings.HasPrefix(mediaType, "audio/"):
+		return ResourceAudio
+	case strings.HasPrefix(mediaType, "font/") || strings.HasPrefix(mediaType, "application/font-"):
+		return ResourceFont
+	case strings.HasPrefix(mediaType, "text/"):
+		return ResourceText
+	}
+	return ResourceOther
+}
+
+// responseHeaders flattens response headers for storage, joining repeated
+// headers with commas
+func responseHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if ignoredResponseHeaders[name] {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// robots.txt files larger than this are truncated, as allowed by RFC 9309
+	maxRobotsSize = 500 * 1024
+	// Upper bound on honored Crawl-delay values
+	maxCrawlDelay = 30 * time.Second
+
+	robotsCacheTTL      = 24 * time.Hour
+	robotsErrorCacheTTL = 10 * time.Minute
+)
+
+// RobotsCache fetches robots.txt files and caches the parsed rules per host
+type RobotsCache struct {
+	client    *http.Client
+	userAgent string
+	mutex     sync.Mutex
+	entries   map[string]*robotsEntry
+	// Fetches in progress, so concurrent misses for a host share one fetch
+	inflight map[string]*robotsFetch
+	// When expired entries were last dropped
+	swept time.Time
+}
+
+type robotsEntry struct {
+	rules     *RobotsRules
+	expiresAt time.Time
+}
+
+// robotsFetch is a robots.txt fetch other callers can wait for. Its rules
+// are nil when the fetch was cancelled.
+type robotsFetch struct {
+	done  chan struct{}
+	rules *RobotsRules
+}
+
+// RobotsRules are the robots.txt rules that apply to our user-agent
+type RobotsRules struct {
+	rules      []robotsRule
+	CrawlDelay time.Duration
+	Sitemaps   []string
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// NewRobotsCache creates a robots.txt cache for the given user-agent
+func NewRobotsCache(client *http.Client, userAgent string) *RobotsCache {
+	return &RobotsCache{
+		client:    client,
+		userAgent: userAgent,
+		entries:   make(map[string]*robotsEntry),
+		inflight:  make(map[string]*robotsFetch),
+	}
+}
+
+// Rules returns the robots.txt rules for the host of the given URL, fetching
+// them if they are not cached or have expired. Callers missing the cache for
+// the same host at once wait for a single fetch. When ctx is cancelled first,
+// nothing is cached and no rules apply.
+func (rc *RobotsCache) Rules(ctx context.Context, u *url.URL) *RobotsRules {
+	key := u.Scheme + "://" + u.Host
+
+	rc.mutex.Lock()
+	for {
+		entry, ok := rc.entries[key]
+		if ok && time.Now().Before(entry.expiresAt) {
+			rc.mutex.Unlock()
+			return entry.rules
+		}
+		fetch, ok := rc.inflight[key]
+		if !ok {
+			break
+		}
+		rc.mutex.Unlock()
+		select {
+		case <-fetch.done:
+		case <-ctx.Done():
+			return &RobotsRules{}
+		}
+		if fetch.rules != nil {
+			return fetch.rules
+		}
+		// The fetch was cancelled with its caller's crawl; start another
+		rc.mutex.Lock()
+	}
+	fetch := &robotsFetch{done: make(chan struct{})}
+	rc.inflight[key] = fetch
+	rc.mutex.Unlock()
+
+	rules, ttl := rc.fetch(ctx, key)
+
+	rc.mutex.Lock()
+	delete(rc.inflight, key)
+	if ctx.Err() == nil {
+		fetch.rules = rules
+		rc.store(key, rules, ttl)
+	}
+	rc.mutex.Unlock()
+	close(fetch.done)
+
+	return rules
+}
+
+// store caches the rules for a host. Entries of hosts no longer crawled are
+// dropped once they expire, at most every robotsErrorCacheTTL. The caller
+// must hold the mutex.
+func (rc *RobotsCache) store(key string, rules *RobotsRules, ttl time.Duration) {
+	now := time.Now()
+	if now.Sub(rc.swept) >= robotsErrorCacheTTL {
+		for host, entry := range rc.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(rc.entries, host)
+			}
+		}
+		rc.swept = now
+	}
+	rc.entries[key] = &robotsEntry{rules: rules, expiresAt: now.Add(ttl)}
+}
+
+// Allowed reports whether our user-agent may fetch the URL. When it may not,
+// the matching rule is returned for reporting.
+func (rc *RobotsCache) Allowed(ctx context.Context, rawURL string) (bool, string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return true, ""
+	}
+	return rc.Rules(ctx, u).Allowed(u)
+}
+
+// fetch downloads and parses robots.txt for a scheme://host origin
+func (rc *RobotsCache) fetch(ctx context.Context, origin string) (*RobotsRules, time.Duration) {
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return &RobotsRules{}, robotsErrorCacheTTL
+	}
+	req.Header.Set("User-Agent", rc.userAgent)
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		// An unreachable host has nothing to enforce; link checks report the failure
+		log.Printf("Failed to fetch robots.txt for %s: %v", origin, err)
+		return &RobotsRules{}, robotsErrorCacheTTL
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		// Server errors mean the site is treated as fully disallowed (RFC 9309)
+		return &RobotsRules{rules: []robotsRule{{allow: false, pattern: "/"}}}, robotsErrorCacheTTL
+	case resp.StatusCode >= 400:
+		// No robots.txt, everything is allowed
+		return &RobotsRules{}, robotsCacheTTL
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	if err != nil {
+		return &RobotsRules{}, robotsErrorCacheTTL
+	}
+
+	return parseRobots(string(body), rc.userAgent), robotsCacheTTL
+}
+
+// parseRobots parses a robots.txt file, keeping only the group that best
+// matches the user-agent and falling back to the "*" group
+func parseRobots(content, userAgent string) *RobotsRules {
+	// The product token is the user-agent up to the first "/" or space
+	token := strings.ToLower(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+
+	type group struct {
+		agents []string
+		rules  []robotsRule
+		delay  time.Duration
+	}
+
+	var groups []*group
+	var current *group
+	var sitemaps []string
+	inAgents := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share one group
+			if !inAgents || current == nil {
+				current = &group{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			// An empty Disallow allows everything and adds no rule
+			if value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.delay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			// Sitemap lines are not tied to a group
+			if value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+		}
+	}
+
+	// Pick the most specific matching group; groups naming the same agent are merged
+	rules := &RobotsRules{Sitemaps: sitemaps}
+	bestScore := -1
+	var matched []*group
+	for _, g := range groups {
+		score := -1
+		for _, agent := range g.agents {
+			if agent == "*" && score < 0 {
+				score = 0
+			} else if agent != "" && agent != "*" && strings.Contains(token, agent) && len(agent) > score {
+				score = len(agent)
+			}
+		}
+		if score < 0 || score < bestScore {
+			continue
+		}
+		if score > bestScore {
+			bestScore = score
+			matched = nil
+		}
+		matched = append(matched, g)
+	}
+
+	for _, g := range matched {
+		rules.rules = append(rules.rules, g.rules...)
+		if g.delay > rules.CrawlDelay {
+			rules.CrawlDelay = g.delay
+		}
+	}
+	if rules.CrawlDelay > maxCrawlDelay {
+		rules.CrawlDelay = maxCrawlDelay
+	}
+
+	return rules
+}
+
+// Allowed reports whether the URL path may be fetched. The longest matching
+// rule wins, and Allow wins a tie.
+func (r *RobotsRules) Allowed(u *url.URL) (bool, string) {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	var best *robotsRule
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if !matchRobotsPattern(rule.pattern, path) {
+			continue
+		}
+		if best == nil || len(rule.pattern) > len(best.pattern) ||
+			(len(rule.pattern) == len(best.pattern) && rule.allow) {
+			best = rule
+		}
+	}
+
+	if best == nil || best.allow {
+		return true, ""
+	}
+	return false, fmt.Sprintf("Disallow: %s", best.pattern)
+}
+
+// matchRobotsPattern matches a path against a robots.txt pattern, where "*"
+// matches any sequence of characters and a trailing "$" anchors the end
+func matchRobotsPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for _, part := range parts[1:] {
+		i := strings.Index(path[pos:], part)
+		if i < 0 {
+			return false
+		}
+		pos += i + len(part)
+	}
+
+	if !anchored {
+		return true
+	}
+	// With an anchor the final literal must end the path
+	last := parts[len(parts)-1]
+	if len(parts) == 1 {
+		return pos == len(path)
+	}
+	return strings.HasSuffix(path, last)
+}
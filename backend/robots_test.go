@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMatchRobotsPattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/", "/anything", true},
+		{"/private", "/private/page", true},
+		{"/private", "/public", false},
+		{"/*.pdf", "/docs/file.pdf", true},
+		{"/*.pdf", "/docs/file.pdf?x=1", true},
+		{"/*.pdf$", "/docs/file.pdf?x=1", false},
+		{"/*.pdf$", "/docs/file.pdf", true},
+		{"/page$", "/page", true},
+		{"/page$", "/page/", false},
+		{"/a*b*c", "/a-x-b-y-c-z", true},
+		{"/a*b*c", "/a-x-c-y-b", false},
+		{"*/admin", "/site/admin", true},
+	}
+	for _, tt := range tests {
+		if got := matchRobotsPattern(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchRobotsPattern(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+const testRobots = `
+# Comments are ignored
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Crawl-delay: 2
+
+User-agent: WebCrawlerBot
+User-agent: OtherBot
+Disallow: /bots-only  # trailing comment
+Disallow:
+Crawl-delay: 120
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		path      string
+		allowed   bool
+		delay     time.Duration
+	}{
+		// The named group replaces the "*" group
+		{"WebCrawlerBot/1.0", "/private", true, maxCrawlDelay},
+		{"WebCrawlerBot/1.0", "/bots-only/page", false, maxCrawlDelay},
+		{"webcrawlerbot", "/bots-only", false, maxCrawlDelay},
+		// Other agents fall back to "*"
+		{"SomeBot/2.0", "/private/page", false, 2 * time.Second},
+		{"SomeBot/2.0", "/private/public/page", true, 2 * time.Second},
+		{"SomeBot/2.0", "/bots-only", true, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		rules := parseRobots(testRobots, tt.userAgent)
+		allowed, _ := rules.Allowed(&url.URL{Path: tt.path})
+		if allowed != tt.allowed {
+			t.Errorf("%s %s: allowed = %v, want %v", tt.userAgent, tt.path, allowed, tt.allowed)
+		}
+		if rules.CrawlDelay != tt.delay {
+			t.Errorf("%s: crawl delay = %v, want %v", tt.userAgent, rules.CrawlDelay, tt.delay)
+		}
+		if len(rules.Sitemaps) != 1 || rules.Sitemaps[0] != "https://example.com/sitemap.xml" {
+			t.Errorf("%s: sitemaps = %v", tt.userAgent, rules.Sitemaps)
+		}
+	}
+}
+
+func TestRobotsAllowedReportsRule(t *testing.T) {
+	rules := parseRobots("User-agent: *\nDisallow: /a\nAllow: /a/b\nDisallow: /a/b/c", "bot")
+	tests := []struct {
+		path string
+		want bool
+		rule string
+	}{
+		{"/", true, ""},
+		{"/a", false, "Disallow: /a"},
+		{"/a/b", true, ""},
+		{"/a/b/c/d", false, "Disallow: /a/b/c"},
+	}
+	for _, tt := range tests {
+		allowed, rule := rules.Allowed(&url.URL{Path: tt.path})
+		if allowed != tt.want || rule != tt.rule {
+			t.Errorf("%s: got %v %q, want %v %q", tt.path, allowed, rule, tt.want, tt.rule)
+		}
+	}
+}
+
+func TestRobotsCacheFetchesOnce(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer srv.Close()
+
+	cache := NewRobotsCache(srv.Client(), "bot")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if allowed, _ := cache.Allowed(context.Background(), srv.URL+"/private/x"); allowed {
+				t.Error("disallowed path was allowed")
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("robots.txt fetched %d times, want 1", n)
+	}
+}
+
+func TestRobotsCacheStatuses(t *testing.T) {
+	tests := []struct {
+		status  int
+		allowed bool
+	}{
+		{http.StatusNotFound, true},
+		{http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		cache := NewRobotsCache(srv.Client(), "bot")
+		if allowed, _ := cache.Allowed(context.Background(), srv.URL+"/page"); allowed != tt.allowed {
+			t.Errorf("status %d: allowed = %v, want %v", tt.status, allowed, tt.allowed)
+		}
+		srv.Close()
+	}
+}
+
+func TestRobotsCacheCancelledFetch(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		fmt.Fprint(w, "User-agent: *\nDisallow: /\n")
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	cache := NewRobotsCache(srv.Client(), "bot")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if allowed, _ := cache.Allowed(ctx, srv.URL+"/page"); !allowed {
+		t.Error("cancelled fetch disallowed the page")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled fetch returned after %v", elapsed)
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("cancelled fetch was cached: %v", cache.entries)
+	}
+}
+
+func TestRobotsCacheDropsExpiredEntries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	cache := NewRobotsCache(srv.Client(), "bot")
+	cache.entries["https://old.example"] = &robotsEntry{rules: &RobotsRules{}, expiresAt: time.Now().Add(-time.Minute)}
+	cache.entries["https://current.example"] = &robotsEntry{rules: &RobotsRules{}, expiresAt: time.Now().Add(time.Hour)}
+
+	cache.Allowed(context.Background(), srv.URL+"/page")
+	if _, ok := cache.entries["https://old.example"]; ok {
+		t.Error("expired entry was kept")
+	}
+	if _, ok := cache.entries["https://current.example"]; !ok {
+		t.Error("entry that has not expired was dropped")
+	}
+	if _, ok := cache.entries[srv.URL]; !ok {
+		t.Error("fetched rules were not cached")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// What a URL rule does with the links it matches
+const (
+	// Check the link and follow it if it is in the site scope
+	RuleFollow = "follow"
+	// Check the link but never crawl it as a page
+	RuleCheckOnly = "check_only"
+	// Count and follow the link but do not check its status
+	RuleSkipCheck = "skip_check"
+	// Drop the link as if it were not on the page
+	RuleIgnore = "ignore"
+)
+
+// How a URL rule's pattern is matched
+const (
+	RuleGlob  = "glob"
+	RuleRegex = "regex"
+)
+
+// Most URL rules a job may have
+const maxURLRules = 100
+
+// URLRule decides what happens to the discovered URLs matching its pattern.
+// Rules are tried in order and the first match wins; links no rule matches
+// are followed.
+type URLRule struct {
+	Pattern string `json:"pattern"`
+	Type    string `json:"type,omitempty"` // glob (default), regex
+	Action  string `json:"action"`         // follow, check_only, skip_check, ignore
+}
+
+type compiledURLRule struct {
+	rule URLRule
+	re   *regexp.Regexp
+	// Glob patterns starting with "/" match the path and query only
+	pathOnly bool
+}
+
+// urlRules is a job's compiled rule list
+type urlRules []compiledURLRule
+
+// compileURLRules validates and compiles a job's rules
+func compileURLRules(rules []URLRule) (urlRules, error) {
+	if len(rules) > maxURLRules {
+		return nil, fmt.Errorf("at most %d url_rules are allowed", maxURLRules)
+	}
+	compiled := make(urlRules, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("url_rules[%d]: pattern is required", i)
+		}
+		switch rule.Action {
+		case RuleFollow, RuleCheckOnly, RuleSkipCheck, RuleIgnore:
+		default:
+			return nil, fmt.Errorf("url_rules[%d]: action must be '%s', '%s', '%s' or '%s'", i, RuleFollow, RuleCheckOnly, RuleSkipCheck, RuleIgnore)
+		}
+
+		c := compiledURLRule{rule: rule}
+		switch rule.Type {
+		case "", RuleGlob:
+			c.re = globRegexp(rule.Pattern)
+			c.pathOnly = strings.HasPrefix(rule.Pattern, "/")
+		case RuleRegex:
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("url_rules[%d]: invalid regex: %v", i, err)
+			}
+			c.re = re
+		default:
+			return nil, fmt.Errorf("url_rules[%d]: type must be '%s' or '%s'", i, RuleGlob, RuleRegex)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// globRegexp turns a glob into an anchored regular expression, where "*"
+// matches any run of characters, "/" included, and "?" a single character
+func globRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// Match returns the first rule matching a URL, or nil
+func (rules urlRules) Match(rawURL string) *URLRule {
+	var pathAndQuery string
+	for i := range rules {
+		target := rawURL
+		if rules[i].pathOnly {
+			if pathAndQuery == "" {
+				pathAndQuery = requestURI(rawURL)
+			}
+			target = pathAndQuery
+		}
+		if rules[i].re.MatchString(target) {
+			return &rules[i].rule
+		}
+	}
+	return nil
+}
+
+// Action returns what to do with a URL under the rules
+func (rules urlRules) Action(rawURL string) string {
+	if rule := rules.Match(rawURL); rule != nil {
+		return rule.Action
+	}
+	return RuleFollow
+}
+
+// requestURI returns the path and query of a URL
+func requestURI(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.RequestURI()
+}
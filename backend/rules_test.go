@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCompileURLRulesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []URLRule
+	}{
+		{"missing pattern", []URLRule{{Action: RuleFollow}}},
+		{"missing action", []URLRule{{Pattern: "/a"}}},
+		{"unknown action", []URLRule{{Pattern: "/a", Action: "skip"}}},
+		{"unknown type", []URLRule{{Pattern: "/a", Type: "prefix", Action: RuleIgnore}}},
+		{"invalid regex", []URLRule{{Pattern: "(", Type: RuleRegex, Action: RuleIgnore}}},
+		{"too many", make([]URLRule, maxURLRules+1)},
+	}
+	for _, tt := range tests {
+		if _, err := compileURLRules(tt.rules); err == nil {
+			t.Errorf("%s: compileURLRules succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestURLRulesAction(t *testing.T) {
+	rules, err := compileURLRules([]URLRule{
+		{Pattern: "/admin/*", Action: RuleIgnore},
+		{Pattern: "/search?q=*", Action: RuleCheckOnly},
+		{Pattern: "https://cdn.example.com/*", Action: RuleSkipCheck},
+		{Pattern: `\.pdf$`, Type: RuleRegex, Action: RuleCheckOnly},
+		{Pattern: "/page-?", Action: RuleIgnore},
+		{Pattern: "/docs/*", Action: RuleFollow},
+		{Pattern: "/docs/*", Action: RuleIgnore},
+	})
+	if err != nil {
+		t.Fatalf("compileURLRules: %v", err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		// Path patterns match on any host
+		{"https://example.com/admin/users", RuleIgnore},
+		{"https://other.example/admin/x/y", RuleIgnore},
+		{"https://example.com/administrator", RuleFollow},
+		// Path patterns see the query
+		{"https://example.com/search?q=go", RuleCheckOnly},
+		{"https://example.com/search", RuleFollow},
+		// Full URL patterns
+		{"https://cdn.example.com/app.js", RuleSkipCheck},
+		{"http://cdn.example.com/app.js", RuleFollow},
+		// Regexes are unanchored and match the full URL
+		{"https://example.com/files/report.pdf", RuleCheckOnly},
+		{"https://example.com/report.pdf?download=1", RuleFollow},
+		// "?" matches exactly one character and "." is literal
+		{"https://example.com/page-2", RuleIgnore},
+		{"https://example.com/page-10", RuleFollow},
+		// The first matching rule wins
+		{"https://example.com/docs/intro", RuleFollow},
+		{"https://example.com/", RuleFollow},
+	}
+	for _, tt := range tests {
+		if got := rules.Action(tt.url); got != tt.want {
+			t.Errorf("Action(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestURLRulesMatchNone(t *testing.T) {
+	var rules urlRules
+	if rule := rules.Match("https://example.com/"); rule != nil {
+		t.Errorf("empty rules matched %+v", rule)
+	}
+	if got := rules.Action("https://example.com/"); got != RuleFollow {
+		t.Errorf("empty rules gave %q, want %q", got, RuleFollow)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// What caused a job to be queued
+const (
+	RunTriggerManual   = "manual"
+	RunTriggerSchedule = "schedule"
+)
+
+// CrawlRun is the result of one execution of a crawl job. The job's own
+// columns summarise its latest run; runs keep every earlier result, and the
+// broken links, pages, skipped links, internal links and redirect chains
+// found by each run reference it through crawl_run_id.
+type CrawlRun struct {
+	ID               uint              `gorm:"primaryKey" json:"id"`
+	CrawlJobID       uint              `gorm:"not null;uniqueIndex:idx_crawl_runs_job_run" json:"crawl_job_id"`
+	UserID           uint              `gorm:"not null;index" json:"user_id"`
+	RunNumber        int               `gorm:"not null;uniqueIndex:idx_crawl_runs_job_run" json:"run_number"`
+	TriggeredBy      string            `gorm:"type:varchar(20)" json:"triggered_by"` // manual, schedule
+	Attempt          int               `json:"attempt"`
+	URL              string            `gorm:"type:text;not null" json:"url"`
+	Mode             string            `gorm:"type:varchar(20)" json:"mode"`
+	MaxDepth         int               `json:"max_depth"`
+	MaxPages         int               `json:"max_pages"`
+	FreshLinkChecks  bool              `json:"fresh_link_checks"` // links were checked without the link cache
+	CheckAssets      bool              `json:"check_assets"`      // assets were checked along with links
+	ProfileID        *uint             `json:"profile_id"`
+	AuthType         string            `gorm:"type:varchar(20)" json:"auth_type"`         // credentials the run authenticated with
+	Settings         CrawlSettings     `gorm:"serializer:json;type:text" json:"settings"` // settings the run crawled with
+	Status           string            `gorm:"type:varchar(20);index" json:"status"`      // running, completed, error, stopped
+	ErrorMessage     string            `gorm:"type:text" json:"error_message,omitempty"`
+	ErrorCode        string            `gorm:"type:varchar(30)" json:"error_code,omitempty"`
+	StartedAt        *time.Time        `json:"started_at"`
+	CompletedAt      *time.Time        `json:"completed_at"`
+	HTMLVersion      string            `json:"html_version"`
+	PageTitle        string            `gorm:"type:text" json:"page_title"`
+	H1Count          int               `json:"h1_count"`
+	H2Count          int               `json:"h2_count"`
+	H3Count          int               `json:"h3_count"`
+	H4Count          int               `json:"h4_count"`
+	H5Count          int               `json:"h5_count"`
+	H6Count          int               `json:"h6_count"`
+	InternalLinks    int               `json:"internal_links"`
+	ExternalLinks    int               `json:"external_links"`
+	BrokenLinks      int               `json:"broken_links"`
+	BrokenAssets     int               `json:"broken_assets"`
+	HasLoginForm     bool              `json:"has_login_form"`
+	MetaTitle        string            `gorm:"type:text" json:"meta_title"`
+	MetaDescription  string            `gorm:"type:text" json:"meta_description"`
+	Canonical        string            `gorm:"type:text" json:"canonical"`
+	HasJSONLD        bool              `json:"has_jsonld"`
+	HasMicrodata     bool              `json:"has_microdata"`
+	HasRDFa          bool              `json:"has_rdfa"`
+	JSONLDSnippet    string            `gorm:"type:text" json:"jsonld_snippet"`
+	MicrodataSnippet string            `gorm:"type:text" json:"microdata_snippet"`
+	RDFaSnippet      string            `gorm:"type:text" json:"rdfa_snippet"`
+	PagesCrawled     int               `json:"pages_crawled"`
+	SkippedLinks     int               `json:"skipped_links"`
+	StatusCode       int               `json:"status_code"`
+	ContentType      string            `gorm:"type:varchar(255)" json:"content_type"`
+	ResourceType     string            `gorm:"type:varchar(20)" json:"resource_type"`
+	ContentLength    int64             `json:"content_length"`
+	ResponseHeaders  map[string]string `gorm:"serializer:json;type:text" json:"response_headers"`
+	ContentHash      string            `gorm:"type:varchar(64)" json:"content_hash"`
+	ContentChanged   bool              `json:"content_changed"`
+	RegionHashes     map[string]string `gorm:"serializer:json;type:text" json:"region_hashes"`
+	ChangedRegions   []string          `gorm:"serializer:json;type:text" json:"changed_regions"`
+	gorm.Model
+}
+
+// startRun records the start of a new run of a job, with the settings it
+// crawls with, and makes it the job's current run
+func (cs *CrawlerService) startRun(job *CrawlJob, settings CrawlSettings, startedAt time.Time) (*CrawlRun, error) {
+	var last int
+	cs.db.Model(&CrawlRun{}).Where("crawl_job_id = ?", job.ID).
+		Select("COALESCE(MAX(run_number), 0)").Scan(&last)
+
+	trigger := job.TriggeredBy
+	if trigger == "" {
+		trigger = RunTriggerManual
+	}
+
+	run := CrawlRun{
+		CrawlJobID:      job.ID,
+		UserID:          job.UserID,
+		RunNumber:       last + 1,
+		TriggeredBy:     trigger,
+		Attempt:         job.Attempts,
+		URL:             job.URL,
+		Mode:            job.Mode,
+		MaxDepth:        job.MaxDepth,
+		MaxPages:        job.MaxPages,
+		FreshLinkChecks: deref(settings.BypassLinkCache) || job.FreshLinkChecks || job.AuthType != "" || settings.private(cs.userAgent),
+		CheckAssets:     deref(settings.CheckAssets),
+		ProfileID:       job.ProfileID,
+		AuthType:        job.AuthType,
+		Settings:        settings,
+		Status:          "running",
+		StartedAt:       &startedAt,
+	}
+	if err := cs.db.Create(&run).Error; err != nil {
+		return nil, err
+	}
+
+	// A fresh check requested for this run does not carry over to later ones
+	cs.db.Model(job).Updates(map[string]interface{}{
+		"current_run_id":    run.ID,
+		"fresh_link_checks": false,
+	})
+	job.CurrentRunID = &run.ID
+	return &run, nil
+}
+
+// finishRun stores the outcome of a run. If the job itself could not be
+// updated because it was stopped or reset meanwhile, the run ends as stopped.
+func (cs *CrawlerService) finishRun(run *CrawlRun, updates map[string]interface{}, jobUpdated bool) {
+	if run == nil {
+		return
+	}
+	if !jobUpdated {
+		completed := time.Now()
+		updates = map[string]interface{}{
+			"status":        "stopped",
+			"error_message": errJobStopped.Error(),
+			"completed_at":  &completed,
+		}
+	}
+	result := cs.db.Model(run).Where("status = ?", "running").Updates(updates)
+	if result.RowsAffected != 1 {
+		return
+	}
+
+	// Alerts compare finished crawls, so stopped runs are left out
+	if status := updates["status"]; status == "completed" || status == "error" {
+		var finished CrawlRun
+		if err := cs.db.First(&finished, run.ID).Error; err == nil {
+			cs.evaluateAlerts(&finished)
+		}
+	}
+}
+
+// closeRun ends a job's current run if it is still marked running, for when
+// the worker that ran it can no longer record the outcome
+func closeRun(job *CrawlJob, status, message string) {
+	if job.CurrentRunID == nil {
+		return
+	}
+	completed := time.Now()
+	db.Model(&CrawlRun{}).
+		Where("id = ? AND status = ?", *job.CurrentRunID, "running").
+		Updates(map[string]interface{}{
+			"status":        status,
+			"error_message": message,
+			"completed_at":  &completed,
+		})
+}
+
+// currentRunID returns the run whose rows describe the job's latest results.
+// Jobs crawled before run history existed have their rows under run 0.
+func currentRunID(job *CrawlJob) uint {
+	if job.CurrentRunID == nil {
+		return 0
+	}
+	return *job.CurrentRunID
+}
+
+// Run handlers
+func getCrawlRuns(c *gin.Context) {
+	var job CrawlJob
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	page := 1
+	limit := 20
+	if p, err := strconv.Atoi(c.Query("page")); err == nil && p > 0 {
+		page = p
+	}
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+
+	var total int64
+	db.Model(&CrawlRun{}).Where("crawl_job_id = ?", job.ID).Count(&total)
+
+	var runs []CrawlRun
+	db.Where("crawl_job_id = ?", job.ID).
+		Order("run_number desc").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&runs)
+
+	c.JSON(http.StatusOK, gin.H{
+		"runs":  runs,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+func getCrawlRun(c *gin.Context) {
+	var run CrawlRun
+	err := db.Where("id = ? AND crawl_job_id = ? AND user_id = ?", c.Param("run_id"), c.Param("id"), c.GetUint("user_id")).
+		First(&run).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Run not found"})
+		return
+	}
+
+	var broken []BrokenLink
+	db.Where("crawl_run_id = ?", run.ID).Find(&broken)
+	brokenLinks, brokenAssets := groupBrokenAssets(broken)
+
+	var pages []CrawlPage
+	db.Where("crawl_run_id = ?", run.ID).Order("depth asc, id asc").Find(&pages)
+
+	var skippedLinks []SkippedLink
+	db.Where("crawl_run_id = ?", run.ID).Find(&skippedLinks)
+
+	var internalLinks []InternalLink
+	db.Where("crawl_run_id = ?", run.ID).Find(&internalLinks)
+
+	var redirectChains []RedirectChain
+	db.Where("crawl_run_id = ?", run.ID).Find(&redirectChains)
+
+	c.JSON(http.StatusOK, gin.H{
+		"run":             run,
+		"broken_links":    brokenLinks,
+		"broken_assets":   brokenAssets,
+		"pages":           pages,
+		"skipped_links":   skippedLinks,
+		"internal_links":  internalLinks,
+		"redirect_chains": redirectChains,
+	})
+}
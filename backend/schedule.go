@@ -0,0 +1,432 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Schedules may not fire more often than this
+const minScheduleInterval = 60
+
+// CrawlSchedule requeues a crawl job on a cron expression or fixed interval
+type CrawlSchedule struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"not null;index" json:"user_id"`
+	CrawlJobID      uint       `gorm:"not null;index" json:"crawl_job_id"`
+	CronExpr        string     `gorm:"type:varchar(100)" json:"cron,omitempty"`
+	IntervalSeconds int        `json:"interval_seconds,omitempty"`
+	Paused          bool       `gorm:"default:false" json:"paused"`
+	NextRunAt       *time.Time `gorm:"index" json:"next_run_at"`
+	LastRunAt       *time.Time `json:"last_run_at"`
+	LastRunStatus   string     `gorm:"type:varchar(20)" json:"last_run_status"` // queued, skipped, failed
+	LastRunMessage  string     `gorm:"type:text" json:"last_run_message,omitempty"`
+	RunCount        int        `gorm:"default:0" json:"run_count"`
+	gorm.Model
+}
+
+// nextRun returns the first run time strictly after t
+func (s *CrawlSchedule) nextRun(t time.Time) (time.Time, error) {
+	if s.CronExpr != "" {
+		cron, err := parseCron(s.CronExpr)
+		if err != nil {
+			return time.Time{}, err
+		}
+		next := cron.Next(t)
+		if next.IsZero() {
+			return next, fmt.Errorf("cron expression %q never matches", s.CronExpr)
+		}
+		return next, nil
+	}
+	return t.Add(time.Duration(s.IntervalSeconds) * time.Second), nil
+}
+
+// cronSchedule is a parsed five-field cron expression. Each field is a bitset
+// of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Whether day of month or day of week started with "*"; when both are
+	// restricted a day matches if either does, as in standard cron
+	domAny, dowAny bool
+}
+
+// cronMacros are the supported shorthand expressions
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var cronMonthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// parseCron parses "minute hour day-of-month month day-of-week". Fields accept
+// "*", values, ranges ("1-5"), steps ("*/15", "10-50/10"), comma-separated
+// lists and month and weekday names. Day of week 7 is Sunday, like 0.
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(strings.ToLower(expr))
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	// Like Vixie cron, a day field starting with "*" (such as "*/2") does not
+	// restrict the day, so the other day field must match as well
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+// parseCronField parses one comma-separated cron field into a bitset
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			n, err := strconv.Atoi(part[slash+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:slash]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			if hi, err = parseCronValue(bounds[1], min, max, names); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			value, err := parseCronValue(part, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+			lo = value
+			// "5/15" means from 5 to the end in steps of 15
+			if step == 1 {
+				hi = value
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a single number or name within a field's bounds
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if n, ok := names[value]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", n, min, max)
+	}
+	return n, nil
+}
+
+// Next returns the first matching minute strictly after t, or the zero time
+// if none exists within five years (e.g. "0 0 31 2 *")
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Scheduler requeues crawl jobs whose schedules are due
+type Scheduler struct {
+	db       *gorm.DB
+	queue    *JobQueue
+	interval time.Duration
+}
+
+// NewScheduler creates a scheduler that checks for due schedules every interval
+func NewScheduler(db *gorm.DB, queue *JobQueue, interval time.Duration) *Scheduler {
+	return &Scheduler{db: db, queue: queue, interval: interval}
+}
+
+// Start runs the scheduler until done is closed
+func (s *Scheduler) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			s.runDue()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// runDue fires every schedule whose next run has passed. Each schedule is
+// claimed by moving next_run_at forward with a conditional update, so when
+// several server instances run, only the one whose update lands fires it.
+func (s *Scheduler) runDue() {
+	now := time.Now()
+
+	var due []CrawlSchedule
+	s.db.Where("paused = ? AND next_run_at <= ?", false, now).Order("next_run_at asc").Find(&due)
+
+	for _, schedule := range due {
+		// Missed runs are not caught up; the schedule resumes from now
+		next, err := schedule.nextRun(now)
+		if err != nil {
+			log.Printf("Pausing schedule %d: %v", schedule.ID, err)
+			s.db.Model(&CrawlSchedule{}).Where("id = ?", schedule.ID).Updates(map[string]interface{}{
+				"paused":           true,
+				"last_run_status":  "failed",
+				"last_run_message": err.Error(),
+			})
+			continue
+		}
+
+		result := s.db.Model(&CrawlSchedule{}).
+			Where("id = ? AND paused = ? AND next_run_at = ?", schedule.ID, false, schedule.NextRunAt).
+			Updates(map[string]interface{}{
+				"next_run_at": &next,
+				"last_run_at": &now,
+				"run_count":   gorm.Expr("run_count + 1"),
+			})
+		if result.RowsAffected != 1 {
+			continue
+		}
+
+		status, message := s.fire(&schedule)
+		s.db.Model(&CrawlSchedule{}).Where("id = ?", schedule.ID).Updates(map[string]interface{}{
+			"last_run_status":  status,
+			"last_run_message": message,
+		})
+	}
+}
+
+// fire requeues a schedule's job unless it is already waiting or running
+func (s *Scheduler) fire(schedule *CrawlSchedule) (string, string) {
+	var job CrawlJob
+	if err := s.db.Where("id = ? AND user_id = ?", schedule.CrawlJobID, schedule.UserID).First(&job).Error; err != nil {
+		return "failed", "Job not found"
+	}
+
+	if job.Status == "running" || (job.Status == "queued" && job.QueuedAt != nil) {
+		return "skipped", fmt.Sprintf("Job was still %s", job.Status)
+	}
+
+	resetCrawlJob(&job)
+	if err := s.queue.Enqueue(&job, RunTriggerSchedule); err != nil {
+		return "failed", "Failed to queue crawl"
+	}
+	log.Printf("Schedule %d queued job %d", schedule.ID, job.ID)
+	return "queued", ""
+}
+
+// Schedule handlers
+func createSchedule(c *gin.Context) {
+	var req struct {
+		CrawlJobID      uint   `json:"crawl_job_id" binding:"required"`
+		Cron            string `json:"cron"`
+		IntervalSeconds int    `json:"interval_seconds"`
+		Paused          bool   `json:"paused"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+
+	var job CrawlJob
+	if err := db.Where("id = ? AND user_id = ?", req.CrawlJobID, userID).First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	if (req.Cron == "") == (req.IntervalSeconds == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either cron or interval_seconds"})
+		return
+	}
+	if req.IntervalSeconds != 0 && req.IntervalSeconds < minScheduleInterval {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("interval_seconds must be at least %d", minScheduleInterval)})
+		return
+	}
+
+	schedule := CrawlSchedule{
+		UserID:          userID,
+		CrawlJobID:      job.ID,
+		CronExpr:        strings.TrimSpace(req.Cron),
+		IntervalSeconds: req.IntervalSeconds,
+		Paused:          req.Paused,
+	}
+
+	next, err := schedule.nextRun(time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cron expression: " + err.Error()})
+		return
+	}
+	schedule.NextRunAt = &next
+
+	if err := db.Create(&schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, schedule)
+}
+
+func getSchedules(c *gin.Context) {
+	query := db.Where("user_id = ?", c.GetUint("user_id"))
+	if jobID := c.Query("crawl_job_id"); jobID != "" {
+		query = query.Where("crawl_job_id = ?", jobID)
+	}
+
+	var schedules []CrawlSchedule
+	query.Order("id asc").Find(&schedules)
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+func getSchedule(c *gin.Context) {
+	schedule, ok := findUserSchedule(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+func pauseSchedule(c *gin.Context) {
+	schedule, ok := findUserSchedule(c)
+	if !ok {
+		return
+	}
+
+	db.Model(schedule).Update("paused", true)
+	schedule.Paused = true
+	c.JSON(http.StatusOK, schedule)
+}
+
+func resumeSchedule(c *gin.Context) {
+	schedule, ok := findUserSchedule(c)
+	if !ok {
+		return
+	}
+
+	// Runs missed while paused are skipped
+	next, err := schedule.nextRun(time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cron expression: " + err.Error()})
+		return
+	}
+
+	db.Model(schedule).Updates(map[string]interface{}{
+		"paused":      false,
+		"next_run_at": &next,
+	})
+	schedule.Paused = false
+	schedule.NextRunAt = &next
+	c.JSON(http.StatusOK, schedule)
+}
+
+func deleteSchedule(c *gin.Context) {
+	result := db.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).Delete(&CrawlSchedule{})
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+}
+
+// findUserSchedule loads the :id schedule of the current user, responding with
+// 404 if it does not exist
+func findUserSchedule(c *gin.Context) (*CrawlSchedule, bool) {
+	var schedule CrawlSchedule
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).First(&schedule).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return nil, false
+	}
+	return &schedule, true
+}
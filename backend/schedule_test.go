@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"x * * * *",
+	}
+	for _, expr := range tests {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-01-01 is a Monday
+	from := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want []time.Time
+	}{
+		{"*/15 * * * *", []time.Time{
+			time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC),
+		}},
+		{"@daily", []time.Time{
+			time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+		}},
+		{"0 9 * * mon-fri", []time.Time{
+			time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 * * 7", []time.Time{
+			time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC),
+		}},
+		{"10/20 2 1 feb,mar *", []time.Time{
+			time.Date(2024, 2, 1, 2, 10, 0, 0, time.UTC),
+			time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC),
+			time.Date(2024, 2, 1, 2, 50, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 2, 10, 0, 0, time.UTC),
+		}},
+		// Both day fields restricted: either may match
+		{"0 0 15 * 5", []time.Time{
+			time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 19, 0, 0, 0, 0, time.UTC),
+		}},
+		// A day field starting with "*" does not restrict: both must match
+		{"0 0 */2 * 1", []time.Time{
+			time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 29 2 *", []time.Time{
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		}},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		next := from
+		for _, want := range tt.want {
+			next = s.Next(next)
+			if !next.Equal(want) {
+				t.Errorf("%q: got %v, want %v", tt.expr, next, want)
+				break
+			}
+		}
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	s, err := parseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Errorf("got %v, want zero time", next)
+	}
+}
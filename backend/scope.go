@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Ways a job's site scope decides which hosts are internal
+const (
+	ScopeHost       = "host"
+	ScopeDomain     = "domain"
+	ScopeSubdomains = "subdomains"
+	ScopePatterns   = "patterns"
+)
+
+// Most hosts or patterns a scope may list
+const maxScopeHosts = 100
+
+// SiteScope defines which hosts belong to a job's site. Links to them count
+// as internal and are followed by site crawls. The job URL's host, and the
+// host its entry page redirects to, are always in scope; ports are ignored.
+type SiteScope struct {
+	Mode  string   `json:"mode,omitempty"`  // host (default), domain, subdomains, patterns
+	Hosts []string `json:"hosts,omitempty"` // extra hosts for subdomains, host globs for patterns
+}
+
+// Validate checks a scope given for a job
+func (s SiteScope) Validate() error {
+	switch s.Mode {
+	case "", ScopeHost, ScopeDomain:
+		if len(s.Hosts) > 0 {
+			return fmt.Errorf("scope hosts are only used with the '%s' and '%s' modes", ScopeSubdomains, ScopePatterns)
+		}
+		return nil
+	case ScopeSubdomains, ScopePatterns:
+	default:
+		return fmt.Errorf("scope mode must be '%s', '%s', '%s' or '%s'", ScopeHost, ScopeDomain, ScopeSubdomains, ScopePatterns)
+	}
+
+	if len(s.Hosts) == 0 {
+		return fmt.Errorf("scope mode '%s' needs at least one host", s.Mode)
+	}
+	if len(s.Hosts) > maxScopeHosts {
+		return fmt.Errorf("at most %d scope hosts are allowed", maxScopeHosts)
+	}
+	for _, host := range s.Hosts {
+		if host == "" || strings.ContainsAny(host, "/:@ ") {
+			return fmt.Errorf("invalid scope host %q", host)
+		}
+		if s.Mode == ScopeSubdomains && strings.ContainsAny(host, "*?[") {
+			return fmt.Errorf("scope host %q contains a pattern; use the '%s' mode", host, ScopePatterns)
+		}
+		if _, err := path.Match(host, ""); err != nil {
+			return fmt.Errorf("invalid scope pattern %q", host)
+		}
+	}
+	return nil
+}
+
+// scopeMatcher applies a job's scope to the hosts of discovered links
+type scopeMatcher struct {
+	scope SiteScope
+	// Hosts of the job URL and its entry page; added to before the entry
+	// page's links are classified
+	roots []string
+}
+
+func newScopeMatcher(jobURL string, scope SiteScope) *scopeMatcher {
+	m := &scopeMatcher{scope: scope}
+	if u, err := url.Parse(jobURL); err == nil {
+		m.addRoot(u.Hostname())
+	}
+	return m
+}
+
+// addRoot puts a host of the site's entry page in scope
+func (m *scopeMatcher) addRoot(host string) {
+	host = canonicalHost(host)
+	if host == "" {
+		return
+	}
+	for _, root := range m.roots {
+		if root == host {
+			return
+		}
+	}
+	m.roots = append(m.roots, host)
+}
+
+// Contains reports whether a host belongs to the site
+func (m *scopeMatcher) Contains(host string) bool {
+	host = canonicalHost(host)
+	for _, root := range m.roots {
+		if host == root {
+			return true
+		}
+	}
+
+	switch m.scope.Mode {
+	case ScopeDomain:
+		domain := registrableDomain(host)
+		for _, root := range m.roots {
+			if registrableDomain(root) == domain {
+				return true
+			}
+		}
+	case ScopeSubdomains:
+		for _, h := range m.scope.Hosts {
+			if canonicalHost(h) == host {
+				return true
+			}
+		}
+	case ScopePatterns:
+		for _, pattern := range m.scope.Hosts {
+			if ok, _ := path.Match(canonicalHost(pattern), host); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canonicalHost lowercases a host name and drops a trailing dot
+func canonicalHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// registrableDomain returns the eTLD+1 of a host, such as example.co.uk for
+// www.example.co.uk, or the host itself for IP addresses and bare suffixes
+func registrableDomain(host string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
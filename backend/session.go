@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// openSession creates the clients a crawl sends its page and link requests
+// with. They share the service's connection pool but carry the crawl's own
+// timeouts and a cookie jar, so cookies set by the site are sent back for the
+// rest of the crawl. A session sending the job's own cookies, headers or
+// User-Agent is private to the job.
+func (cs *CrawlerService) openSession(state *crawlState) {
+	state.privateSession = state.settings.private(cs.userAgent)
+	jar := newSessionJar(state.settings.Cookies, state.scope)
+	var transport http.RoundTripper = cs.transport
+	if len(state.settings.Headers) > 0 {
+		header := make(http.Header, len(state.settings.Headers))
+		for name, value := range state.settings.Headers {
+			header.Set(name, value)
+		}
+		transport = &scopedHeaderTransport{base: cs.transport, header: header, scope: state.scope}
+	}
+	// Pages and links are fetched hop by hop so their redirect chains can be
+	// recorded
+	state.pageClient = &http.Client{
+		Timeout:       state.settings.pageTimeout(),
+		Transport:     transport,
+		CheckRedirect: noRedirects,
+		Jar:           jar,
+	}
+	state.linkClient = &http.Client{
+		Timeout:       state.settings.linkTimeout(),
+		Transport:     transport,
+		CheckRedirect: noRedirects,
+		Jar:           jar,
+	}
+}
+
+// scopedHeaderTransport adds headers, such as a job's custom headers or its
+// Authorization header, to requests to hosts in the job's site scope,
+// including redirect hops, and to no others. They replace headers of the
+// same name the request already has.
+type scopedHeaderTransport struct {
+	base   http.RoundTripper
+	header http.Header
+	scope  *scopeMatcher
+}
+
+func (t *scopedHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.scope.Contains(req.URL.Hostname()) {
+		return t.base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+// sessionJar keeps the cookies of a crawl, and adds the cookies configured
+// for it to requests to hosts in the site's scope
+type sessionJar struct {
+	jar   http.CookieJar
+	fixed []*http.Cookie
+	scope *scopeMatcher
+}
+
+func newSessionJar(cookies map[string]string, scope *scopeMatcher) *sessionJar {
+	// The public suffix list keeps sites from setting cookies for a whole TLD
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	s := &sessionJar{jar: jar, scope: scope}
+	for name, value := range cookies {
+		s.fixed = append(s.fixed, &http.Cookie{Name: name, Value: value})
+	}
+	return s
+}
+
+func (s *sessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.jar.SetCookies(u, cookies)
+}
+
+// Cookies returns the cookies to send to u. A cookie the site has set takes
+// precedence over a configured one of the same name.
+func (s *sessionJar) Cookies(u *url.URL) []*http.Cookie {
+	cookies := s.jar.Cookies(u)
+	if len(s.fixed) == 0 || !s.scope.Contains(u.Hostname()) {
+		return cookies
+	}
+	set := make(map[string]bool, len(cookies))
+	for _, c := range cookies {
+		set[c.Name] = true
+	}
+	for _, c := range s.fixed {
+		if !set[c.Name] {
+			cookies = append(cookies, c)
+		}
+	}
+	return cookies
+}
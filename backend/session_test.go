@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// recordingTransport answers every request and keeps the last one it saw
+type recordingTransport struct {
+	last *http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.last = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestScopedHeaderTransport(t *testing.T) {
+	base := &recordingTransport{}
+	transport := &scopedHeaderTransport{
+		base:   base,
+		header: http.Header{"Authorization": {"Bearer secret"}, "X-Api-Key": {"key"}, "User-Agent": {"Custom/1.0"}},
+		scope:  newScopeMatcher("https://example.com/", SiteScope{}),
+	}
+
+	tests := []struct {
+		url     string
+		inScope bool
+	}{
+		{"https://example.com/page", true},
+		{"http://EXAMPLE.com:8080/other", true},
+		{"https://cdn.other.net/app.js", false},
+		{"https://example.com.evil.net/", false},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "Crawler/1.0")
+		req.Header.Set("Range", "bytes=0-0")
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+
+		sent := base.last.Header
+		if got := sent.Get("Authorization") != ""; got != tt.inScope {
+			t.Errorf("%s: Authorization sent = %v, want %v", tt.url, got, tt.inScope)
+		}
+		if got := sent.Get("X-Api-Key") != ""; got != tt.inScope {
+			t.Errorf("%s: X-Api-Key sent = %v, want %v", tt.url, got, tt.inScope)
+		}
+		wantAgent := "Crawler/1.0"
+		if tt.inScope {
+			wantAgent = "Custom/1.0"
+		}
+		if got := sent.Get("User-Agent"); got != wantAgent {
+			t.Errorf("%s: User-Agent = %q, want %q", tt.url, got, wantAgent)
+		}
+		if sent.Get("Range") != "bytes=0-0" {
+			t.Errorf("%s: Range header was dropped", tt.url)
+		}
+		// The caller's request is left as it was
+		if req.Header.Get("Authorization") != "" || req.Header.Get("User-Agent") != "Crawler/1.0" {
+			t.Errorf("%s: RoundTrip modified the request it was given", tt.url)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// The sitemap protocol caps files at 50MB uncompressed
+	maxSitemapSize = 50 * 1024 * 1024
+	// Upper bound on sitemap files read during one discovery
+	maxSitemapFiles = 50
+
+	defaultSitemapLimit = 1000
+	maxSitemapLimit     = 10000
+)
+
+// sitemapDocument covers both <urlset> and <sitemapindex> documents
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// SitemapDiscovery is the outcome of reading a site's sitemaps
+type SitemapDiscovery struct {
+	URLs      []string
+	Sitemaps  []string
+	Errors    []string
+	Truncated bool
+}
+
+// DiscoverSitemapURLs collects page URLs from a site's sitemaps. siteURL may be
+// a sitemap itself; otherwise sitemaps are taken from robots.txt Sitemap lines
+// and /sitemap.xml. Sitemap index files are followed and gzip files unpacked.
+// Cancelling ctx stops the fetches.
+func (cs *CrawlerService) DiscoverSitemapURLs(ctx context.Context, siteURL string, limit int) (*SitemapDiscovery, error) {
+	base, err := url.Parse(siteURL)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid URL: %s", siteURL)
+	}
+
+	var queue []string
+	if isSitemapURL(base) {
+		queue = append(queue, base.String())
+	} else {
+		origin := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/"}
+		queue = append(queue, cs.robots.Rules(ctx, origin).Sitemaps...)
+		queue = append(queue, base.Scheme+"://"+base.Host+"/sitemap.xml")
+	}
+
+	discovery := &SitemapDiscovery{}
+	seenSitemaps := make(map[string]bool)
+	seenURLs := make(map[string]bool)
+
+	for len(queue) > 0 && len(seenSitemaps) < maxSitemapFiles {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+		if seenSitemaps[sitemapURL] {
+			continue
+		}
+		seenSitemaps[sitemapURL] = true
+
+		doc, err := cs.fetchSitemap(ctx, sitemapURL)
+		if err != nil {
+			discovery.Errors = append(discovery.Errors, fmt.Sprintf("%s: %v", sitemapURL, err))
+			continue
+		}
+		discovery.Sitemaps = append(discovery.Sitemaps, sitemapURL)
+
+		for _, s := range doc.Sitemaps {
+			if loc := strings.TrimSpace(s.Loc); loc != "" {
+				queue = append(queue, loc)
+			}
+		}
+		for _, u := range doc.URLs {
+			loc := normalizeURL(strings.TrimSpace(u.Loc), URLNormalization{})
+			if loc == "" || seenURLs[loc] || !isCrawlable(loc) {
+				continue
+			}
+			if len(discovery.URLs) >= limit {
+				discovery.Truncated = true
+				break
+			}
+			seenURLs[loc] = true
+			discovery.URLs = append(discovery.URLs, loc)
+		}
+		if discovery.Truncated {
+			break
+		}
+	}
+
+	if len(discovery.Sitemaps) == 0 {
+		return discovery, fmt.Errorf("no sitemap found for %s", base.Host)
+	}
+	return discovery, nil
+}
+
+// fetchSitemap downloads and parses one sitemap or sitemap index file
+func (cs *CrawlerService) fetchSitemap(ctx context.Context, sitemapURL string) (*sitemapDocument, error) {
+	if allowed, rule := cs.robotsAllowed(ctx, sitemapURL); !allowed {
+		return nil, fmt.Errorf("blocked by robots.txt (%s)", rule)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", sitemapURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", cs.userAgent)
+
+	resp, err := cs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	// Detect gzip from the content rather than trusting names or headers
+	reader := bufio.NewReader(resp.Body)
+	var body io.Reader = reader
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip data: %v", err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var doc sitemapDocument
+	if err := xml.NewDecoder(io.LimitReader(body, maxSitemapSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid sitemap XML: %v", err)
+	}
+	if doc.XMLName.Local != "urlset" && doc.XMLName.Local != "sitemapindex" {
+		return nil, fmt.Errorf("unexpected root element <%s>", doc.XMLName.Local)
+	}
+	return &doc, nil
+}
+
+// isSitemapURL reports whether a URL points directly at a sitemap file
+func isSitemapURL(u *url.URL) bool {
+	path := strings.ToLower(u.Path)
+	return strings.HasSuffix(path, ".xml") || strings.HasSuffix(path, ".xml.gz")
+}
+
+// importSitemap creates crawl jobs from the URLs in a site's sitemaps, either
+// one page job per URL or a single site crawl seeded with them
+func importSitemap(c *gin.Context) {
+	var req struct {
+		URL      string `json:"url" binding:"required"`
+		Mode     string `json:"mode"` // jobs, site
+		Limit    int    `json:"limit"`
+		MaxDepth int    `json:"max_depth"`
+		MaxPages int    `json:"max_pages"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = "jobs"
+	}
+	if req.Mode != "jobs" && req.Mode != CrawlModeSite {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Mode must be 'jobs' or 'site'"})
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultSitemapLimit
+	}
+	if req.Limit > maxSitemapLimit {
+		req.Limit = maxSitemapLimit
+	}
+
+	siteURL, err := normalizeInputURL(req.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL format"})
+		return
+	}
+	if err := destinations.CheckURL(c.Request.Context(), siteURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	discovery, err := crawlerService.DiscoverSitemapURLs(c.Request.Context(), siteURL, req.Limit)
+	if err != nil {
+		response := gin.H{"error": err.Error()}
+		if discovery != nil {
+			response["sitemap_errors"] = discovery.Errors
+		}
+		c.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+	if len(discovery.URLs) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Sitemaps contain no URLs", "sitemaps": discovery.Sitemaps})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	log.Printf("Imported %d URLs from %d sitemaps for %s", len(discovery.URLs), len(discovery.Sitemaps), siteURL)
+
+	if req.Mode == CrawlModeSite {
+		// Budget for at least every sitemap URL unless told otherwise
+		if req.MaxPages == 0 {
+			req.MaxPages = len(discovery.URLs) + 1
+			if req.MaxPages > maxSitePages {
+				req.MaxPages = maxSitePages
+			}
+		}
+		_, maxDepth, maxPages, err := resolveCrawlBudget(CrawlModeSite, req.MaxDepth, req.MaxPages)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		root, _ := url.Parse(siteURL)
+		job := CrawlJob{
+			UserID:   userID,
+			URL:      root.Scheme + "://" + root.Host + "/",
+			Status:   "queued",
+			Mode:     CrawlModeSite,
+			MaxDepth: maxDepth,
+			MaxPages: maxPages,
+		}
+
+		tx := db.Begin()
+		if err := tx.Create(&job).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create crawl job"})
+			return
+		}
+		seeds := make([]CrawlSeed, 0, len(discovery.URLs))
+		for _, u := range discovery.URLs {
+			seeds = append(seeds, CrawlSeed{CrawlJobID: job.ID, URL: u})
+		}
+		if err := tx.CreateInBatches(seeds, 500).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store sitemap seeds"})
+			return
+		}
+		tx.Commit()
+
+		c.JSON(http.StatusCreated, gin.H{
+			"job":       job,
+			"seeds":     len(seeds),
+			"sitemaps":  discovery.Sitemaps,
+			"errors":    discovery.Errors,
+			"truncated": discovery.Truncated,
+		})
+		return
+	}
+
+	jobs := make([]CrawlJob, 0, len(discovery.URLs))
+	for _, u := range discovery.URLs {
+		jobs = append(jobs, CrawlJob{
+			UserID:   userID,
+			URL:      u,
+			Status:   "queued",
+			Mode:     CrawlModePage,
+			MaxPages: 1,
+		})
+	}
+	if err := db.CreateInBatches(jobs, 500).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create crawl jobs"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"created":   len(jobs),
+		"sitemaps":  discovery.Sitemaps,
+		"errors":    discovery.Errors,
+		"truncated": discovery.Truncated,
+	})
+}
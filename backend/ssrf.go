@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Address ranges outbound requests may not connect to unless an allowed range
+// covers them: loopback, private, link-local (cloud metadata included),
+// carrier-grade NAT, multicast and other reserved ranges
+var defaultDeniedRanges = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"2001::/32", // Teredo, which tunnels to an embedded IPv4 address
+	"2001:db8::/32",
+	"2002::/16", // 6to4, likewise
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// How long CheckURL waits to resolve a host
+const destinationLookupTimeout = 5 * time.Second
+
+// blockedAddressError is returned for connections a DestinationPolicy refuses
+type blockedAddressError struct {
+	addr netip.Addr
+}
+
+func (e *blockedAddressError) Error() string {
+	return fmt.Sprintf("blocked by SSRF protection: %s is not an allowed destination", e.addr)
+}
+
+// fetchErrorCode returns the error code stored with a job or page whose fetch
+// failed, or "" for failures without one
+func fetchErrorCode(err error) string {
+	var blocked *blockedAddressError
+	if errors.As(err, &blocked) {
+		return LinkErrorSSRFBlocked
+	}
+	return ""
+}
+
+// DestinationPolicy decides which IP addresses outbound requests for user
+// supplied URLs may connect to. It is applied when dialing, after DNS
+// resolution, so redirects and hosts that change what they resolve to are
+// covered as well as the URLs users submit.
+type DestinationPolicy struct {
+	enabled bool
+	deny    []netip.Prefix
+	// Exceptions to deny, such as an internal staging network
+	allow []netip.Prefix
+}
+
+// NewDestinationPolicy creates a policy denying the default ranges and the
+// given ones, except for the allowed ranges. Ranges are comma-separated CIDRs
+// or single addresses.
+func NewDestinationPolicy(enabled bool, deny, allow string) (*DestinationPolicy, error) {
+	p := &DestinationPolicy{enabled: enabled}
+	var err error
+	if p.deny, err = parsePrefixes(strings.Join(defaultDeniedRanges, ",") + "," + deny); err != nil {
+		return nil, err
+	}
+	if p.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address range %q", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address range %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Allowed reports whether requests may connect to an address
+func (p *DestinationPolicy) Allowed(addr netip.Addr) bool {
+	if p == nil || !p.enabled {
+		return true
+	}
+	// IPv4-mapped IPv6 addresses are checked as IPv4, and zones are ignored
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range p.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	for _, prefix := range p.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// control refuses connections to addresses that are not allowed. It runs for
+// every address a dialer tries, once the host has been resolved.
+func (p *DestinationPolicy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !p.Allowed(addr) {
+		return &blockedAddressError{addr: addr.Unmap()}
+	}
+	return nil
+}
+
+// DialContext returns a dial function for transports that enforces the policy
+func (p *DestinationPolicy) DialContext() func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.control,
+	}
+	return dialer.DialContext
+}
+
+// CheckURL resolves a URL's host and returns an error if any of its addresses
+// is not allowed, so users learn about blocked URLs when they submit them.
+// Hosts that cannot be resolved yet are left to fail when they are fetched.
+func (p *DestinationPolicy) CheckURL(ctx context.Context, rawURL string) error {
+	if p == nil || !p.enabled {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !p.Allowed(addr) {
+			return &blockedAddressError{addr: addr.Unmap()}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, destinationLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !p.Allowed(addr) {
+			return &blockedAddressError{addr: addr.Unmap()}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestDestinationPolicyAllowed(t *testing.T) {
+	policy, err := NewDestinationPolicy(true, "198.51.0.0/16, 2606:4700::1", "10.20.0.0/16,fd00:1::/32")
+	if err != nil {
+		t.Fatalf("NewDestinationPolicy: %v", err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fe80::1%eth0", false},
+		{"fd12:3456::1", false},
+		{"ff02::1", false},
+		// IPv4-mapped IPv6 addresses are checked as IPv4
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:10.20.1.1", true},
+		{"::ffff:93.184.216.34", true},
+		// IPv6 forms embedding an IPv4 address
+		{"64:ff9b::7f00:1", false},
+		{"2002:7f00:1::1", false},
+		{"2001:0:4136:e378:8000:63bf:80ff:fffe", false},
+		// Configured ranges
+		{"198.51.7.7", false},
+		{"2606:4700::1", false},
+		{"2606:4700::2", true},
+		// Allowed ranges override the denied ones
+		{"10.20.3.4", true},
+		{"10.21.0.1", false},
+		{"fd00:1::5", true},
+	}
+	for _, tt := range tests {
+		if got := policy.Allowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestDestinationPolicyDisabled(t *testing.T) {
+	policy, err := NewDestinationPolicy(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var none *DestinationPolicy
+	for _, p := range []*DestinationPolicy{policy, none} {
+		if !p.Allowed(netip.MustParseAddr("127.0.0.1")) {
+			t.Errorf("%v blocked 127.0.0.1", p)
+		}
+		if err := p.CheckURL(context.Background(), "http://127.0.0.1/"); err != nil {
+			t.Errorf("%v: CheckURL: %v", p, err)
+		}
+	}
+}
+
+func TestNewDestinationPolicyInvalidRanges(t *testing.T) {
+	tests := []struct{ deny, allow string }{
+		{"10.0.0.0/33", ""},
+		{"not-an-ip", ""},
+		{"", "10.0.0"},
+		{"", "fd00::/129"},
+	}
+	for _, tt := range tests {
+		if _, err := NewDestinationPolicy(true, tt.deny, tt.allow); err == nil {
+			t.Errorf("NewDestinationPolicy(%q, %q) succeeded, want an error", tt.deny, tt.allow)
+		}
+	}
+}
+
+func TestDestinationPolicyCheckURL(t *testing.T) {
+	policy, err := NewDestinationPolicy(true, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"http://127.0.0.1:8080/", true},
+		{"http://[::ffff:127.0.0.1]/", true},
+		{"https://[fe80::1%25eth0]/", true},
+		{"http://93.184.216.34/", false},
+		// Hosts that do not resolve are left to fail when fetched
+		{"http://missing.invalid/", false},
+	}
+	for _, tt := range tests {
+		err := policy.CheckURL(context.Background(), tt.url)
+		var blocked *blockedAddressError
+		if got := errors.As(err, &blocked); got != tt.blocked {
+			t.Errorf("CheckURL(%q) = %v, want blocked %v", tt.url, err, tt.blocked)
+		}
+	}
+}
+
+func TestBlockedAddressErrorUnmapped(t *testing.T) {
+	policy, err := NewDestinationPolicy(true, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = policy.control("tcp6", "[::ffff:127.0.0.1]:80", nil)
+	want := "blocked by SSRF protection: 127.0.0.1 is not an allowed destination"
+	if err == nil || err.Error() != want {
+		t.Errorf("control = %v, want %q", err, want)
+	}
+	if err := policy.control("tcp4", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("control allowed address: %v", err)
+	}
+}
+
+func TestFetchErrorCode(t *testing.T) {
+	blocked := &blockedAddressError{addr: netip.MustParseAddr("10.0.0.1")}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("refused to fetch URL: %w", blocked), LinkErrorSSRFBlocked},
+		{&url.Error{Op: "Get", URL: "http://10.0.0.1/", Err: blocked}, LinkErrorSSRFBlocked},
+		{errors.New("failed to fetch URL: connection refused"), ""},
+	}
+	for _, tt := range tests {
+		if got := fetchErrorCode(tt.err); got != tt.want {
+			t.Errorf("fetchErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
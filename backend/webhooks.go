@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Webhook event names
+const (
+	WebhookJobCompleted = "job.completed"
+	WebhookJobError     = "job.error"
+	WebhookJobStopped   = "job.stopped"
+	WebhookAlert        = "alert.triggered"
+)
+
+// validWebhookEvents lists the events a webhook can subscribe to
+var validWebhookEvents = []string{WebhookJobCompleted, WebhookJobError, WebhookJobStopped, WebhookAlert}
+
+const (
+	// Deliveries are retried with exponential backoff until this many attempts
+	maxWebhookAttempts = 6
+	webhookRetryBase   = 30 * time.Second
+	webhookRetryMax    = time.Hour
+	// A claimed delivery is retried by another dispatcher if not finished in time
+	webhookClaimTimeout = time.Minute
+	// Response bodies are stored up to this size for debugging
+	maxWebhookResponseBody = 1024
+)
+
+// Webhook is a user-registered endpoint notified about job events
+type Webhook struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	UserID uint   `gorm:"not null;index" json:"user_id"`
+	URL    string `gorm:"type:text;not null" json:"url"`
+	Secret string `gorm:"type:varchar(100);not null" json:"-"`
+	Events string `gorm:"type:varchar(255)" json:"events"` // comma-separated event names
+	Active bool   `gorm:"default:true" json:"active"`
+	gorm.Model
+}
+
+// WebhookDelivery is one payload sent, or to be sent, to a webhook
+type WebhookDelivery struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	WebhookID     uint       `gorm:"not null;index" json:"webhook_id"`
+	CrawlJobID    uint       `gorm:"index" json:"crawl_job_id"`
+	Event         string     `gorm:"type:varchar(50)" json:"event"`
+	Payload       string     `gorm:"type:mediumtext" json:"payload,omitempty"`
+	Status        string     `gorm:"type:varchar(20);index" json:"status"` // pending, succeeded, failed
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at"`
+	ResponseCode  int        `json:"response_code"`
+	ResponseBody  string     `gorm:"type:text" json:"response_body,omitempty"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	RedeliveryOf  *uint      `json:"redelivery_of,omitempty"`
+	gorm.Model
+}
+
+// webhookPayload is the JSON body posted to webhook endpoints
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Job       *CrawlJob `json:"job,omitempty"`
+	Alert     *Alert    `json:"alert,omitempty"`
+}
+
+// WebhookDispatcher delivers pending webhook deliveries in the background.
+// Deliveries are stored before they are sent, so retries survive restarts.
+type WebhookDispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+	wake   chan struct{}
+}
+
+// NewWebhookDispatcher creates a webhook dispatcher. Deliveries connect only
+// where destinations allow.
+func NewWebhookDispatcher(db *gorm.DB, destinations *DestinationPolicy) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db: db,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext:         destinations.DialContext(),
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			// Receivers must answer directly rather than bounce payloads elsewhere
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Start runs the delivery loop until done is closed
+func (d *WebhookDispatcher) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			d.deliverDue()
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Notify wakes the delivery loop
+func (d *WebhookDispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// JobFinished queues a delivery to every active webhook of the job's owner
+// subscribed to the event for the job's final status
+func (d *WebhookDispatcher) JobFinished(job *CrawlJob, status string) {
+	event := "job." + status
+
+	// Send the job as stored, with its final results
+	var stored CrawlJob
+	if err := d.db.First(&stored, job.ID).Error; err != nil {
+		return
+	}
+	d.queueEvent(stored.UserID, stored.ID, webhookPayload{
+		Event:     event,
+		CreatedAt: time.Now(),
+		Job:       &stored,
+	})
+}
+
+// AlertTriggered queues a delivery of an alert to every active webhook of
+// the alert's owner subscribed to alerts
+func (d *WebhookDispatcher) AlertTriggered(alert *Alert) {
+	var job CrawlJob
+	if err := d.db.First(&job, alert.CrawlJobID).Error; err != nil {
+		return
+	}
+	d.queueEvent(alert.UserID, alert.CrawlJobID, webhookPayload{
+		Event:     WebhookAlert,
+		CreatedAt: time.Now(),
+		Job:       &job,
+		Alert:     alert,
+	})
+}
+
+// queueEvent stores a pending delivery of an event to each subscribed webhook
+func (d *WebhookDispatcher) queueEvent(userID, jobID uint, event webhookPayload) {
+	var hooks []Webhook
+	d.db.Where("user_id = ? AND active = ?", userID, true).Find(&hooks)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode webhook payload for job %d: %v", jobID, err)
+		return
+	}
+
+	queued := 0
+	for _, hook := range hooks {
+		if !hook.subscribedTo(event.Event) {
+			continue
+		}
+		now := time.Now()
+		d.db.Create(&WebhookDelivery{
+			WebhookID:     hook.ID,
+			CrawlJobID:    jobID,
+			Event:         event.Event,
+			Payload:       string(payload),
+			Status:        "pending",
+			NextAttemptAt: &now,
+		})
+		queued++
+	}
+	if queued > 0 {
+		d.Notify()
+	}
+}
+
+// subscribedTo reports whether the webhook wants an event
+func (w *Webhook) subscribedTo(event string) bool {
+	for _, e := range strings.Split(w.Events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverDue sends every delivery whose next attempt is due
+func (d *WebhookDispatcher) deliverDue() {
+	var due []WebhookDelivery
+	d.db.Where("status = ? AND next_attempt_at <= ?", "pending", time.Now()).
+		Order("next_attempt_at asc").
+		Limit(50).
+		Find(&due)
+
+	for _, delivery := range due {
+		// Claim the delivery by pushing its next attempt out, so another
+		// server instance does not send it at the same time
+		claimUntil := time.Now().Add(webhookClaimTimeout)
+		result := d.db.Model(&WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at = ?", delivery.ID, "pending", delivery.NextAttemptAt).
+			Update("next_attempt_at", &claimUntil)
+		if result.RowsAffected != 1 {
+			continue
+		}
+		d.attempt(&delivery)
+	}
+}
+
+// attempt sends a delivery once and records the outcome
+func (d *WebhookDispatcher) attempt(delivery *WebhookDelivery) {
+	var hook Webhook
+	if err := d.db.First(&hook, delivery.WebhookID).Error; err != nil {
+		d.db.Model(delivery).Updates(map[string]interface{}{
+			"status":          "failed",
+			"last_error":      "webhook no longer exists",
+			"next_attempt_at": nil,
+		})
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+	statusCode, body, err := d.send(&hook, delivery)
+
+	updates := map[string]interface{}{
+		"attempts":      attempts,
+		"response_code": statusCode,
+		"response_body": body,
+	}
+	switch {
+	case err == nil && statusCode >= 200 && statusCode < 300:
+		now := time.Now()
+		updates["status"] = "succeeded"
+		updates["delivered_at"] = &now
+		updates["last_error"] = ""
+		updates["next_attempt_at"] = nil
+	default:
+		if err != nil {
+			updates["last_error"] = err.Error()
+		} else {
+			updates["last_error"] = fmt.Sprintf("unexpected response status %d", statusCode)
+		}
+		if attempts >= maxWebhookAttempts {
+			updates["status"] = "failed"
+			updates["next_attempt_at"] = nil
+			log.Printf("Webhook delivery %d to %s failed after %d attempts", delivery.ID, hook.URL, attempts)
+		} else {
+			next := time.Now().Add(webhookBackoff(attempts))
+			updates["next_attempt_at"] = &next
+		}
+	}
+	d.db.Model(delivery).Updates(updates)
+}
+
+// send posts a delivery's payload with its signature headers
+func (d *WebhookDispatcher) send(hook *Webhook, delivery *WebhookDelivery) (int, string, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", crawlerService.userAgent)
+	req.Header.Set("X-Webhook-ID", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(hook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseBody))
+	return resp.StatusCode, string(body), nil
+}
+
+// signWebhook computes the hex HMAC-SHA256 of "timestamp.payload". Receivers
+// should recompute it and reject stale timestamps to prevent replays.
+func signWebhook(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay before the next attempt
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookRetryBase << (attempts - 1)
+	if delay > webhookRetryMax || delay <= 0 {
+		delay = webhookRetryMax
+	}
+	return delay
+}
+
+// generateWebhookSecret creates a signing secret for a new webhook
+func generateWebhookSecret() string {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		log.Fatal("Failed to generate webhook secret:", err)
+	}
+	return "whsec_" + hex.EncodeToString(bytes)
+}
+
+// Webhook handlers
+func createWebhook(c *gin.Context) {
+	var req struct {
+		URL    string   `json:"url" binding:"required"`
+		Events []string `json:"events"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	parsed, err := url.ParseRequestURI(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook URL must be an absolute http or https URL"})
+		return
+	}
+	if err := destinations.CheckURL(c.Request.Context(), req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Events) == 0 {
+		req.Events = validWebhookEvents
+	}
+	for _, event := range req.Events {
+		valid := false
+		for _, known := range validWebhookEvents {
+			if event == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown event %q", event)})
+			return
+		}
+	}
+
+	hook := Webhook{
+		UserID: c.GetUint("user_id"),
+		URL:    req.URL,
+		Secret: generateWebhookSecret(),
+		Events: strings.Join(req.Events, ","),
+		Active: true,
+	}
+	if err := db.Create(&hook).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	// The secret is only ever returned here
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": hook,
+		"secret":  hook.Secret,
+	})
+}
+
+func getWebhooks(c *gin.Context) {
+	var hooks []Webhook
+	db.Where("user_id = ?", c.GetUint("user_id")).Order("id asc").Find(&hooks)
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks})
+}
+
+func deleteWebhook(c *gin.Context) {
+	result := db.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).Delete(&Webhook{})
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+func getWebhookDeliveries(c *gin.Context) {
+	hook, ok := findUserWebhook(c)
+	if !ok {
+		return
+	}
+
+	page := 1
+	limit := 20
+	if p, err := strconv.Atoi(c.Query("page")); err == nil && p > 0 {
+		page = p
+	}
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+
+	query := db.Model(&WebhookDelivery{}).Where("webhook_id = ?", hook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	// Payloads can be large, so the list leaves them out
+	var deliveries []WebhookDelivery
+	query.Omit("payload").
+		Order("id desc").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&deliveries)
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"total":      total,
+		"page":       page,
+		"limit":      limit,
+	})
+}
+
+func getWebhookDelivery(c *gin.Context) {
+	hook, ok := findUserWebhook(c)
+	if !ok {
+		return
+	}
+
+	var delivery WebhookDelivery
+	if err := db.Where("id = ? AND webhook_id = ?", c.Param("delivery_id"), hook.ID).First(&delivery).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
+
+func redeliverWebhook(c *gin.Context) {
+	hook, ok := findUserWebhook(c)
+	if !ok {
+		return
+	}
+
+	var original WebhookDelivery
+	if err := db.Where("id = ? AND webhook_id = ?", c.Param("delivery_id"), hook.ID).First(&original).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+
+	// Redelivery sends the original payload again as a new delivery
+	now := time.Now()
+	delivery := WebhookDelivery{
+		WebhookID:     hook.ID,
+		CrawlJobID:    original.CrawlJobID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        "pending",
+		NextAttemptAt: &now,
+		RedeliveryOf:  &original.ID,
+	}
+	if err := db.Create(&delivery).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue redelivery"})
+		return
+	}
+	webhookDispatcher.Notify()
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// findUserWebhook loads the :id webhook of the current user, responding with
+// 404 if it does not exist
+func findUserWebhook(c *gin.Context) (*Webhook, bool) {
+	var hook Webhook
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).First(&hook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return nil, false
+	}
+	return &hook, true
+}